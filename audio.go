@@ -0,0 +1,369 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"qm-go/ffprobe"
+)
+
+// sample rates that both aac and mp3 can be encoded at
+var encoderSampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+
+// presetSampleRate is the sample rate used for each preset when --sample-rate isn't set, 0 keeps the input's
+func presetSampleRate() int {
+	return []int{0, 32000, 22050, 16000, 11025, 8000, 4000}[preset-1]
+}
+
+// presetBitcrush is the bit depth used for each preset when --bitcrush isn't set, 0 doesn't crush the audio
+func presetBitcrush() int {
+	return []int{0, 0, 0, 12, 8, 6, 4}[preset-1]
+}
+
+// makeCrushFilter makes the filters that make the audio sound cheap: downmixing to mono, lowering the sample rate, and
+// lowering the bit depth
+func makeCrushFilter(mono bool, sampleRate int, bits int) string {
+	var filter strings.Builder
+
+	if mono {
+		filter.WriteString(",aformat=channel_layouts=mono")
+	}
+
+	if sampleRate != 0 {
+		filter.WriteString(",aresample=" + strconv.Itoa(sampleRate))
+
+		// the encoders only take some sample rates, so anything else is brought back up to one they take
+		// the quality that was lost stays lost
+		if encoderRate := encoderSampleRate(sampleRate); encoderRate != sampleRate {
+			filter.WriteString(",aresample=" + strconv.Itoa(encoderRate))
+		}
+	}
+
+	if bits != 0 {
+		filter.WriteString(",acrusher=bits=" + strconv.Itoa(bits) + ":mode=lin:mix=1")
+	}
+
+	if debug {
+		log.Print("crush filter is ", filter.String())
+	}
+
+	return filter.String()
+}
+
+// encoderSampleRate finds the lowest sample rate the encoders can use that's at least sampleRate
+func encoderSampleRate(sampleRate int) int {
+	for _, rate := range encoderSampleRates {
+		if rate >= sampleRate {
+			return rate
+		}
+	}
+	return encoderSampleRates[len(encoderSampleRates)-1]
+}
+
+// voicing is a device the audio can be made to sound like it's coming out of
+type voicing struct {
+	lowCut, highCut int     // the frequencies the device can play, in Hz
+	ratio           float64 // how hard the audio is compressed
+	drive           float64 // how much the audio is pushed into clipping, in dB
+	noise           float64 // volume of the squelch noise (0 = none)
+	noiseDuration   float64 // how long the squelch noise plays at the start, in seconds (0 = the whole time)
+}
+
+var audioVoices = map[string]voicing{
+	"phone":     {lowCut: 300, highCut: 3400, ratio: 4, drive: 6},
+	"radio":     {lowCut: 200, highCut: 5000, ratio: 6, drive: 4, noise: 0.02},
+	"walkie":    {lowCut: 400, highCut: 3000, ratio: 8, drive: 12, noise: 0.3, noiseDuration: 0.3},
+	"megaphone": {lowCut: 600, highCut: 4500, ratio: 10, drive: 18},
+}
+
+// makeVoiceFilter makes the filters for --audio-voice: compression, distortion, squelch noise, then a bandpass so the
+// noise sounds like it came through the device too
+func makeVoiceFilter(name string) string {
+	voice := audioVoices[name]
+	var filter strings.Builder
+
+	filter.WriteString(",acompressor=threshold=0.05:ratio=" + strconv.FormatFloat(voice.ratio, 'f', -1, 64) + ":attack=5:release=50:makeup=2")
+
+	// push the audio into a soft clipper, then bring it back down
+	drive := strconv.FormatFloat(voice.drive, 'f', -1, 64)
+	filter.WriteString(",volume=" + drive + "dB,asoftclip=type=atan,volume=-" + drive + "dB")
+
+	// the squelch noise, which isn't normalized so the audio doesn't get louder when the noise stops
+	if voice.noise != 0 {
+		noiseSource := "anoisesrc=color=white:amplitude=" + strconv.FormatFloat(voice.noise, 'f', -1, 64)
+		if voice.noiseDuration != 0 {
+			noiseSource += ":duration=" + strconv.FormatFloat(voice.noiseDuration, 'f', -1, 64)
+		}
+		filter.WriteString(mixGenerated(noiseSource, "voicenoise"))
+	}
+
+	filter.WriteString(",highpass=f=" + strconv.Itoa(voice.lowCut) + ":poles=2,highpass=f=" + strconv.Itoa(voice.lowCut) + ":poles=2")
+	filter.WriteString(",lowpass=f=" + strconv.Itoa(voice.highCut) + ":poles=2,lowpass=f=" + strconv.Itoa(voice.highCut) + ":poles=2")
+
+	if debug {
+		log.Print("audio voice is ", name)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// makeEarrapeFilter makes the filters for --earrape, which gets louder and more distorted with each level
+// lower levels are overdriven into soft clipping, higher levels are hard clipped and bitcrushed
+func makeEarrapeFilter(level int) string {
+	l := float64(level)
+	var filter strings.Builder
+
+	// the audio is turned up before every clipping stage, so the higher the level the more of it gets clipped
+	gain := strconv.FormatFloat(l*4, 'f', -1, 64)
+	if level <= 5 {
+		filter.WriteString(",volume=" + gain + "dB,asoftclip=type=tanh")
+		// overdrive it again for a rougher sound
+		if level >= 3 {
+			filter.WriteString(",volume=" + strconv.FormatFloat(l*2, 'f', -1, 64) + "dB,asoftclip=type=atan")
+		}
+	} else {
+		filter.WriteString(",volume=" + gain + "dB,asoftclip=type=hard,volume=" + gain + "dB,asoftclip=type=hard")
+		filter.WriteString(",acrusher=bits=" + strconv.Itoa(16-level) + ":mode=lin:mix=1")
+	}
+
+	if debug {
+		log.Print("earrape is ", level)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// makeLimiterFilter makes a limiter that keeps the audio's peaks under peak dB
+func makeLimiterFilter(peak float64) string {
+	limiterFilter := ",alimiter=limit=" + strconv.FormatFloat(math.Pow(10, peak/20), 'f', 4, 64) + ":level=false"
+	if debug {
+		log.Print("limiter filter is ", limiterFilter)
+	}
+	return limiterFilter
+}
+
+// bassCurves are the filters --bass-curve can boost the bass with, with the gain left off the end
+var bassCurves = map[string]string{
+	"shelf": "bass=f=110:w=0.6:g=",       // everything under about 110 Hz
+	"sub":   "equalizer=f=50:t=q:w=1:g=", // just the sub bass
+	"wide":  "bass=f=250:w=0.3:g=",       // most of the low end, muddier
+}
+
+// makeBassBoostFilter makes the filters for --bass-boost
+// the audio is turned down before the boost so it has room for it, optionally distorted, then brought back up to full
+// volume with a limiter, so --volume after it still works the same
+func makeBassBoostFilter(gain float64, curve string, distort bool) string {
+	var filter strings.Builder
+
+	g := strconv.FormatFloat(gain, 'f', -1, 64)
+	filter.WriteString(",volume=-" + g + "dB," + bassCurves[curve] + g)
+
+	if distort {
+		filter.WriteString(",volume=" + strconv.FormatFloat(gain/2, 'f', -1, 64) + "dB,asoftclip=type=tanh")
+	}
+
+	filter.WriteString(",alimiter=limit=0.9:level=true")
+
+	if debug {
+		log.Print("bass boost is ", gain)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// the sample rate the audio is resampled to before its rate is changed by --pitch or --chipmunk, so the change can be
+// undone without knowing the input's sample rate
+const pitchSampleRate = 48000
+
+// makePitchFilter changes the pitch of the audio by semitones without changing its speed, by playing it faster or
+// slower (which changes both) then changing the speed back
+func makePitchFilter(semitones float64) string {
+	ratio := math.Pow(2, semitones/12)
+	pitchFilter := makeTapeSpeedFilter(ratio) + makeTempoFilter(1/ratio)
+	if debug {
+		log.Print("pitch filter is ", pitchFilter)
+	}
+	return pitchFilter
+}
+
+// makeTapeSpeedFilter plays the audio ratio times faster, which also changes the pitch, like speeding up a tape
+func makeTapeSpeedFilter(ratio float64) string {
+	rate := strconv.Itoa(pitchSampleRate)
+	return ",aresample=" + rate + ",asetrate=" + strconv.FormatFloat(pitchSampleRate*ratio, 'f', 0, 64) + ",aresample=" + rate
+}
+
+// makeTempoFilter changes the speed of the audio without changing its pitch
+// older versions of ffmpeg can only change the tempo by 0.5 to 2 at once, so bigger changes are split into multiple atempo
+// filters that multiply out to the same tempo
+func makeTempoFilter(tempo float64) string {
+	var filter strings.Builder
+	for tempo > 2 {
+		filter.WriteString(",atempo=2")
+		tempo /= 2
+	}
+	for tempo < 0.5 {
+		filter.WriteString(",atempo=0.5")
+		tempo /= 0.5
+	}
+	if tempo != 1 {
+		filter.WriteString(",atempo=" + strconv.FormatFloat(tempo, 'f', -1, 64))
+	}
+	return filter.String()
+}
+
+// echoPresets are the aecho settings for --echo, which are a few separate repeats
+var echoPresets = map[string]string{
+	"room": "aecho=0.8:0.7:60|120:0.4|0.2",
+	"cave": "aecho=0.8:0.9:500|1000|1500:0.5|0.3|0.15",
+}
+
+// reverbPresets are the aecho settings for --reverb, which are a lot of quick repeats that blur together
+var reverbPresets = map[string]string{
+	"room": "aecho=0.8:0.8:20|35|50|65:0.4|0.3|0.25|0.2",
+	"cave": "aecho=0.8:0.85:60|90|130|170|230:0.5|0.45|0.4|0.35|0.3",
+}
+
+// how long each slice that --audio-stutter repeats is, in seconds
+const audioStutterSlice = 0.08
+
+// how many times each slice is played by --audio-stutter
+const audioStutterRepeats = 4
+
+// makeAudioStutterFilter makes the filters for --audio-stutter, which repeats short slices of the audio at random
+// places (level times every 10 seconds on average) using seed
+// the audio is split into copies that are each delayed by one more slice than the last, and the copy that's heard is
+// switched every slice while stuttering, so the slice repeats and the audio skips ahead afterwards to stay in sync
+// whether to stutter is picked at random for each stutter-long window of the audio, so the filter is the same size no
+// matter how long the audio is
+func makeAudioStutterFilter(level int, seed int) string {
+	stutterLength := audioStutterSlice * audioStutterRepeats
+	slice := strconv.FormatFloat(audioStutterSlice, 'f', -1, 64)
+
+	// 1 while the window t is in is stuttering
+	window := "floor(t/" + strconv.FormatFloat(stutterLength, 'f', -1, 64) + ")"
+	stuttering := "lt(" + seededRandom(seed, 10, window) + "," + strconv.FormatFloat(float64(level)*stutterLength/10, 'f', -1, 64) + ")"
+	position := "mod(t," + strconv.FormatFloat(stutterLength, 'f', -1, 64) + ")"
+
+	// gate is an expression that's 1 while the copy at index should be heard
+	gate := func(index int) string {
+		return stuttering + "*gte(" + position + "," + strconv.Itoa(index) + "*" + slice + ")*lt(" + position + "," + strconv.Itoa(index+1) + "*" + slice + ")"
+	}
+
+	var filter strings.Builder
+	// small frames so the copies can be switched at the right time
+	filter.WriteString(",asetnsamples=n=256,asplit=" + strconv.Itoa(audioStutterRepeats))
+	var copies []string
+	for i := 0; i < audioStutterRepeats; i++ {
+		copies = append(copies, newLabel("stuttercopy"))
+		filter.WriteString(copies[i])
+	}
+	var mix strings.Builder
+	for i, copyLabel := range copies {
+		gated := newLabel("stuttergated")
+		filter.WriteString(";" + copyLabel)
+		if i == 0 {
+			// the undelayed copy is heard whenever the audio isn't stuttering, and while the first slice plays
+			filter.WriteString("volume=volume='1-" + stuttering + "*gte(" + position + "," + slice + ")':eval=frame")
+		} else {
+			filter.WriteString("adelay=delays=" + strconv.FormatFloat(float64(i)*audioStutterSlice*1000, 'f', -1, 64) + ":all=1,volume=volume='" + gate(i) + "':eval=frame")
+		}
+		filter.WriteString(gated)
+		mix.WriteString(gated)
+	}
+	filter.WriteString(";" + mix.String() + "amix=inputs=" + strconv.Itoa(audioStutterRepeats) + ":duration=first:normalize=0")
+
+	if debug {
+		log.Print("audio stutter is ", level)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// mixGenerated mixes the audio generated by source (like anoisesrc or aevalsrc) under the audio, for as long as the audio
+// lasts, without changing the volume of either
+func mixGenerated(source string, name string) string {
+	base := newLabel(name + "base")
+	generated := newLabel(name)
+	return base + ";" + source + generated + ";" + base + generated + "amix=inputs=2:duration=first:normalize=0"
+}
+
+// noiseBeds are the sources for --noise-bed, with the level from 1 to 10 filled in
+var noiseBeds = map[string]func(level float64) string{
+	// pops and clicks at random, with the high end taken off so they sound like dust on a record
+	"crackle": func(level float64) string {
+		return "aevalsrc=exprs='" + strconv.FormatFloat(level*0.08, 'f', -1, 64) + "*gt(random(0)," + strconv.FormatFloat(1-level*0.0001, 'f', -1, 64) + ")*(2*random(1)-1)':s=48000,lowpass=f=6000"
+	},
+	"hiss": func(level float64) string {
+		return "anoisesrc=color=white:amplitude=" + strconv.FormatFloat(level*0.01, 'f', -1, 64) + ",highpass=f=3000"
+	},
+	// mains hum at 60 Hz and its harmonics
+	"hum": func(level float64) string {
+		return "aevalsrc=exprs='" + strconv.FormatFloat(level*0.01, 'f', -1, 64) + "*(sin(2*PI*60*t)+0.5*sin(2*PI*120*t)+0.25*sin(2*PI*180*t))':s=48000"
+	},
+	"static": func(level float64) string {
+		return "anoisesrc=color=white:amplitude=" + strconv.FormatFloat(level*0.02, 'f', -1, 64)
+	},
+}
+
+// makeNoiseBedFilter mixes the --noise-bed noise under the audio at level (1-10)
+func makeNoiseBedFilter(name string, level int) string {
+	noiseFilter := mixGenerated(noiseBeds[name](float64(level)), "noisebed")
+	if debug {
+		log.Print("noise bed is ", name, " at ", level)
+		log.Println(noiseFilter)
+	}
+	return noiseFilter
+}
+
+// makeMixAudioFilter makes the filters for --mix-audio, which mixes the audio from input under the audio
+// it's mixed in after the speed and pitch are changed, so it plays as it is and its timing is in the output's time
+// the added audio is moved by --audio-offset, turned up or down by --audio-gain, and faded in and out, with the fade
+// out ending when the clip (clipLength seconds long) or the added audio ends
+func makeMixAudioFilter(input int, clipLength float64) string {
+	var track strings.Builder
+
+	if audioOffset > 0 {
+		track.WriteString(",adelay=delays=" + strconv.FormatFloat(audioOffset*1000, 'f', 0, 64) + ":all=1")
+	} else if audioOffset < 0 {
+		track.WriteString(",atrim=start=" + strconv.FormatFloat(-audioOffset, 'f', -1, 64) + ",asetpts=PTS-STARTPTS")
+	}
+
+	if audioGain != 0 {
+		track.WriteString(",volume=" + strconv.FormatFloat(audioGain, 'f', -1, 64) + "dB")
+	}
+
+	if mixFadeIn != 0 {
+		track.WriteString(",afade=t=in:st=" + strconv.FormatFloat(math.Max(audioOffset, 0), 'f', -1, 64) + ":d=" + strconv.FormatFloat(mixFadeIn, 'f', -1, 64))
+	}
+
+	if mixFadeOut != 0 {
+		trackEnd := clipLength
+		if !audioLoop {
+			if mixData, err := ffprobe.ProbeData(mixAudio); err == nil && mixData.Duration != 0 {
+				trackEnd = math.Min(trackEnd, mixData.Duration+audioOffset)
+			}
+		}
+		track.WriteString(",afade=t=out:st=" + strconv.FormatFloat(math.Max(trackEnd-mixFadeOut, 0), 'f', -1, 64) + ":d=" + strconv.FormatFloat(mixFadeOut, 'f', -1, 64))
+	}
+
+	trackChain := strings.TrimPrefix(track.String(), ",")
+	if trackChain == "" {
+		trackChain = "anull"
+	}
+	base := newLabel("mixbase")
+	mixed := newLabel("mixtrack")
+	filter := base + ";[" + strconv.Itoa(input) + ":a:0]" + trackChain + mixed + ";" + base + mixed + "amix=inputs=2:duration=first:normalize=0"
+
+	if debug {
+		log.Print("mixing in ", mixAudio)
+		log.Println(filter)
+	}
+
+	return filter
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"qm-go/fonts"
+)
+
+// makeVHSFilter makes the filters for the VHS effect: washed out colors, a camcorder timestamp, chroma bleed, blur,
+// horizontal jitter, a rolling tracking band, head switching noise at the bottom, and tape noise
+func makeVHSFilter(outWidth int, outHeight int, level int, input string) string {
+	l := float64(level)
+	var filter strings.Builder
+
+	// washed out colors
+	filter.WriteString(",eq=saturation=" + strconv.FormatFloat(1-l*0.05, 'f', -1, 64) + ":contrast=" + strconv.FormatFloat(1-l*0.02, 'f', -1, 64) + ":brightness=" + strconv.FormatFloat(l*0.005, 'f', -1, 64))
+
+	// the timestamp is part of the recording, so it's drawn before everything else messes it up
+	filter.WriteString(makeTextFilter(outWidth, outHeight, vhsTextOverlays(), input))
+
+	// chroma bleeds to the side and is shifted away from the luma (the chroma planes are half size, so the radius can't be too big)
+	chromaRadius := int(math.Min(math.Max(1, l*float64(outWidth)/640), float64(int(math.Min(float64(outWidth), float64(outHeight)))/4)))
+	chromaShift := strconv.Itoa(int(math.Max(1, math.Round(l*float64(outWidth)/960))))
+	filter.WriteString(",boxblur=luma_radius=0:luma_power=0:chroma_radius=" + strconv.Itoa(chromaRadius) + ":chroma_power=1,chromashift=cbh=" + chromaShift + ":crh=-" + chromaShift)
+
+	// soft blur
+	filter.WriteString(",gblur=sigma=" + strconv.FormatFloat(l*0.08, 'f', -1, 64))
+
+	// the frame sometimes jumps left or right
+	jitter := strconv.Itoa(int(math.Max(1, math.Round(l*float64(outWidth)/640))))
+	filter.WriteString(",pad=w=iw+2*" + jitter + ":h=ih:x=" + jitter + ":y=0:color=black,crop=w=iw-2*" + jitter + ":h=ih:x='" + jitter + "+" + jitter + "*(2*random(0)-1)*gt(random(1)," + strconv.FormatFloat(1-l*0.04, 'f', -1, 64) + ")':y=0")
+
+	// a noisy tracking band that rolls down the frame
+	bandHeight := int(math.Max(2, math.Round(float64(outHeight)*l/100)))
+	base := newLabel("vhsbase")
+	band := newLabel("vhsband")
+	filter.WriteString(base + ";color=c=gray:s=" + strconv.Itoa(outWidth) + "x" + strconv.Itoa(bandHeight) + ",noise=alls=80:allf=t,format=rgba,colorchannelmixer=aa=" + strconv.FormatFloat(l*0.05, 'f', -1, 64) + band)
+	filter.WriteString(";" + base + band + "overlay=x=0:y='mod(t*H/5+H/3,H+h)-h':shortest=1")
+
+	// head switching noise at the bottom of the frame, then noise over everything
+	filter.WriteString(",drawbox=x=0:y=ih-ih/40:w=iw:h=ih/40:color=white@0.3:t=fill,noise=alls=" + strconv.Itoa(level*2) + ":allf=t")
+
+	if debug {
+		log.Print("vhs is ", level)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// vhsTextOverlays returns the text a camcorder would put on the recording: PLAY in the top left, and a clock
+// (starting at the current time and going along with the video) and the date in the bottom left
+func vhsTextOverlays() []textOverlay {
+	now := time.Now()
+	_, zoneOffset := now.Zone()
+
+	play := defaultTextOverlay()
+	play.Text = "PLAY"
+	play.Font = vhsFont()
+	play.Size = 6
+	play.X = 5
+	play.Y = 5
+	play.Shadow = 0.4
+
+	clock := play
+	clock.Text = "{pts:gmtime:" + strconv.FormatInt(now.Unix()+int64(zoneOffset), 10) + ":%p %I:%M:%S}\n{date:Jan. 02 2006}"
+	clock.Y = 95
+
+	return []textOverlay{play, clock}
+}
+
+// vhsFont returns the first font that looks like a camcorder's on screen display, or an empty string (the built-in font)
+// if none of them are installed
+func vhsFont() string {
+	candidates := []string{"VCR OSD Mono", "Consolas", "Courier New", "DejaVu Sans Mono"}
+	for _, candidate := range candidates {
+		if _, err := fonts.Find(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// makeVHSAudioFilter makes the audio filters for the VHS effect: wow and flutter, a high cut, and tape hiss
+func makeVHSAudioFilter(level int) string {
+	l := float64(level)
+	var filter strings.Builder
+
+	// wow (slow) and flutter (fast) from the tape speed wobbling
+	filter.WriteString(",vibrato=f=0.7:d=" + strconv.FormatFloat(l*0.02, 'f', -1, 64) + ",vibrato=f=9:d=" + strconv.FormatFloat(l*0.01, 'f', -1, 64))
+
+	// high frequencies are the first thing to go
+	filter.WriteString(",lowpass=f=" + strconv.Itoa(14000-level*1000))
+
+	// tape hiss
+	filter.WriteString(mixGenerated("anoisesrc=color=pink:amplitude="+strconv.FormatFloat(l*0.004, 'f', -1, 64), "vhshiss"))
+
+	if debug {
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// how many screen pixels each output pixel becomes with the CRT effect, 3 so each pixel gets a red, green, and blue phosphor
+const crtPixelSize = 3
+
+// makeCRTFilter makes the filters for the CRT effect
+// each output pixel is blown up so it gets its own scanline and phosphors, then it gets bloom, a curved screen, and a vignette
+// the frame ends up crtPixelSize times bigger in both directions
+func makeCRTFilter(level int) string {
+	l := float64(level)
+	var filter strings.Builder
+
+	pixelSize := strconv.Itoa(crtPixelSize)
+
+	// blow up the pixels without smoothing them, in RGB so the phosphor mask can be applied to each color
+	// this goes by the size of the frame coming in, since captions can make it bigger than the output resolution
+	filter.WriteString(",scale=iw*" + pixelSize + ":ih*" + pixelSize + ":flags=neighbor,format=gbrp")
+
+	// the mask is made from the first frame so it's the same size, and only once, since blend keeps using the last frame of it
+	// the last row of each pixel is darkened for the scanlines, and each column is a red, green, or blue phosphor
+	scanline := "(1-" + strconv.FormatFloat(0.2+l*0.05, 'f', -1, 64) + "*eq(mod(Y," + pixelSize + ")," + pixelSize + "-1))"
+	phosphor := strconv.FormatFloat(l*0.03, 'f', -1, 64)
+	phosphorExpr := func(column int) string {
+		return "(1-" + phosphor + "*(1-eq(mod(X," + pixelSize + ")," + strconv.Itoa(column) + ")))"
+	}
+	base := newLabel("crtbase")
+	maskCopy := newLabel("crtmaskcopy")
+	mask := newLabel("crtmask")
+	filter.WriteString(",split" + base + maskCopy + ";" + maskCopy + "trim=end_frame=1,geq=r='255*" + scanline + "*" + phosphorExpr(0) + "':g='255*" + scanline + "*" + phosphorExpr(1) + "':b='255*" + scanline + "*" + phosphorExpr(2) + "'" + mask)
+	filter.WriteString(";" + base + mask + "blend=all_mode=multiply")
+
+	// bloom, from a blurred copy screened on top
+	bloomBase := newLabel("crtbloombase")
+	bloomCopy := newLabel("crtbloomcopy")
+	bloom := newLabel("crtbloom")
+	filter.WriteString(",split" + bloomBase + bloomCopy + ";" + bloomCopy + "gblur=sigma=" + strconv.FormatFloat(1+l*0.3, 'f', -1, 64) + bloom)
+	filter.WriteString(";" + bloomBase + bloom + "blend=all_mode=screen:all_opacity=" + strconv.FormatFloat(l*0.04, 'f', -1, 64))
+
+	// curve the screen
+	filter.WriteString(",format=yuv444p,lenscorrection=k1=" + strconv.FormatFloat(l*0.01, 'f', -1, 64) + ":k2=" + strconv.FormatFloat(l*0.005, 'f', -1, 64))
+
+	// vignette, using --vignette if it's set
+	crtVignette := vignette
+	if crtVignette == 0 {
+		crtVignette = 1.5
+	}
+	filter.WriteString(",vignette=PI/(5/(" + strconv.FormatFloat(crtVignette, 'f', -1, 64) + "/2)),format=yuv420p")
+
+	if debug {
+		log.Print("crt is ", level)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// makeRGBSplitFilter makes the filters for the RGB split effect, which moves the red plane one way and the blue plane
+// the other way by amount pixels at angle degrees (0 is right, 90 is down)
+// with jitter, the red and blue planes are each moved a random amount up to that every frame, using seed
+func makeRGBSplitFilter(amount int, angle float64, jitter bool, seed int) string {
+	var filter strings.Builder
+
+	radians := angle * math.Pi / 180
+	x := float64(amount) * math.Cos(radians)
+	y := float64(amount) * math.Sin(radians)
+
+	if !jitter {
+		shiftX := strconv.Itoa(int(math.Round(x)))
+		shiftY := strconv.Itoa(int(math.Round(y)))
+		negShiftX := strconv.Itoa(-int(math.Round(x)))
+		negShiftY := strconv.Itoa(-int(math.Round(y)))
+		filter.WriteString(",rgbashift=rh=" + shiftX + ":rv=" + shiftY + ":bh=" + negShiftX + ":bv=" + negShiftY + ":edge=smear")
+	} else {
+		// rgbashift can't change every frame, so the planes are moved with geq instead
+		redAmount := "(2*" + seededRandom(seed, 0, "N") + "-1)"
+		blueAmount := "(2*" + seededRandom(seed, 1, "N") + "-1)"
+		fx := strconv.FormatFloat(x, 'f', -1, 64)
+		fy := strconv.FormatFloat(y, 'f', -1, 64)
+		filter.WriteString(",format=gbrp,geq=r='r(X-round(" + fx + "*" + redAmount + "),Y-round(" + fy + "*" + redAmount + "))':g='g(X,Y)':b='b(X+round(" + fx + "*" + blueAmount + "),Y+round(" + fy + "*" + blueAmount + "))'")
+	}
+
+	if debug {
+		log.Print("rgb split is ", amount)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// seededRandom returns an expression for a random number from 0 to 1 that changes every frame, and is always the same
+// for the same seed, frame, and index (so different random numbers can be used in the same filter)
+// frame is the name of the filter's frame number variable, since it isn't the same in every filter
+func seededRandom(seed int, index int, frame string) string {
+	return "mod(abs(sin((" + frame + "+" + strconv.Itoa(seed*7919+index*104729) + ")*12.9898)*43758.5453),1)"
+}
+
+// seededPixelRandom is like seededRandom, but the number is different for every pixel too, for geq
+func seededPixelRandom(seed int, index int) string {
+	return "mod(abs(sin(X*12.9898+Y*78.233+(N+" + strconv.Itoa(seed*7919+index*104729) + ")*37.719)*43758.5453),1)"
+}
+
+// makeFilmFilter makes the filters for the old film effect: dust specks, vertical scratches, flicker, and grain
+// the dust and scratches are drawn with geq on a copy of the video (so it's the same size and frame rate), then multiplied over the luma
+func makeFilmFilter(level int, seed int) string {
+	l := float64(level)
+	var filter strings.Builder
+
+	// each pixel has a small chance of being a dark speck of dust
+	dust := "(1-0.8*gt(" + seededPixelRandom(seed, 9) + "," + strconv.FormatFloat(1-l*0.0003, 'f', -1, 64) + "))"
+
+	// two scratches that show up at random places in some frames
+	scratches := ""
+	for i := 0; i < 2; i++ {
+		visible := "gt(" + seededRandom(seed, 5+i*2, "N") + "," + strconv.FormatFloat(1-l*0.05, 'f', -1, 64) + ")"
+		x := "W*" + seededRandom(seed, 6+i*2, "N")
+		scratches += "*(1-0.6*" + visible + "*lt(abs(X-" + x + "),1))"
+	}
+
+	base := newLabel("filmbase")
+	copied := newLabel("filmcopy")
+	marks := newLabel("filmmarks")
+	filter.WriteString(",format=yuv420p,split" + base + copied)
+	filter.WriteString(";" + copied + "geq=lum='255*" + dust + scratches + "':cb=128:cr=128" + marks)
+	filter.WriteString(";" + base + marks + "blend=c0_mode=multiply:c1_mode=normal:c2_mode=normal")
+
+	// the brightness flickers a little every frame, then everything gets grain
+	filter.WriteString(",eq=brightness='" + strconv.FormatFloat(l*0.015, 'f', -1, 64) + "*(2*" + seededRandom(seed, 4, "n") + "-1)':eval=frame")
+	filter.WriteString(",noise=alls=" + strconv.Itoa(level*2) + ":allf=t")
+
+	if debug {
+		log.Print("film is ", level)
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
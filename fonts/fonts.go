@@ -0,0 +1,490 @@
+package fonts
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/flopp/go-findfont"
+)
+
+// Font is a font file on the system along with its family and full names
+type Font struct {
+	Path  string
+	Names []string
+}
+
+var ErrNotFound = errors.New("font not found")
+
+// Builtin is DejaVu Sans Bold (see LICENSE-builtin.txt), for when a font can't be found
+//
+//go:embed builtin.ttf
+var Builtin []byte
+
+var errInvalidFont = errors.New("invalid or unsupported font file")
+
+// Find looks for a font by path, file name, or family name (in that order), falling back to a partial file name match
+func Find(font string) (string, error) {
+	if info, err := os.Stat(font); err == nil && !info.IsDir() {
+		return font, nil
+	}
+
+	allFonts := List()
+	needle := strings.ToLower(strings.TrimSuffix(font, filepath.Ext(font)))
+	if !isFontFile(font) {
+		needle = strings.ToLower(font)
+	}
+
+	// exact file name, ignoring the extension
+	for _, f := range allFonts {
+		if strings.ToLower(stripExt(filepath.Base(f.Path))) == needle {
+			return f.Path, nil
+		}
+	}
+
+	// family or full name, using the shortest file name since that's usually the regular style (arial.ttf instead of arialbd.ttf)
+	match := ""
+	for _, f := range allFonts {
+		for _, name := range f.Names {
+			if strings.EqualFold(name, font) && isShorter(f.Path, match) {
+				match = f.Path
+			}
+		}
+	}
+	if match != "" {
+		return match, nil
+	}
+
+	// shortest file name containing the font
+	for _, f := range allFonts {
+		if strings.Contains(strings.ToLower(stripExt(filepath.Base(f.Path))), needle) && isShorter(f.Path, match) {
+			match = f.Path
+		}
+	}
+	if match != "" {
+		return match, nil
+	}
+
+	return "", ErrNotFound
+}
+
+var (
+	listOnce  sync.Once
+	fontsList []Font
+)
+
+// List returns every ttf, otf, and ttc font on the system, sorted by name
+// font names are read once per run and cached on disk, so only new or changed fonts are read again
+func List() []Font {
+	listOnce.Do(func() {
+		cache := loadCache()
+		changed := false
+
+		for _, path := range findfont.List() {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			entry, ok := cache[path]
+			if !ok || entry.ModTime != info.ModTime().Unix() || entry.Size != info.Size() {
+				names, _ := Names(path)
+				entry = cacheEntry{ModTime: info.ModTime().Unix(), Size: info.Size(), Names: names}
+				cache[path] = entry
+				changed = true
+			}
+
+			fontsList = append(fontsList, Font{Path: path, Names: entry.Names})
+		}
+
+		// remove fonts that no longer exist from the cache
+		if len(cache) != len(fontsList) {
+			stillExists := map[string]bool{}
+			for _, f := range fontsList {
+				stillExists[f.Path] = true
+			}
+			for path := range cache {
+				if !stillExists[path] {
+					delete(cache, path)
+					changed = true
+				}
+			}
+		}
+
+		if changed {
+			saveCache(cache)
+		}
+
+		sort.Slice(fontsList, func(i, j int) bool {
+			return strings.ToLower(fontName(fontsList[i])) < strings.ToLower(fontName(fontsList[j]))
+		})
+	})
+
+	return fontsList
+}
+
+// fontName returns the first name of a font, or its file name if it doesn't have one
+func fontName(f Font) string {
+	if len(f.Names) != 0 {
+		return f.Names[0]
+	}
+	return filepath.Base(f.Path)
+}
+
+type cacheEntry struct {
+	ModTime int64    `json:"mod_time"`
+	Size    int64    `json:"size"`
+	Names   []string `json:"names"`
+}
+
+func cachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "qm-go", "fonts.json")
+}
+
+func loadCache() map[string]cacheEntry {
+	cache := map[string]cacheEntry{}
+	path := cachePath()
+	if path == "" {
+		return cache
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	// a broken cache is just rebuilt
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return map[string]cacheEntry{}
+	}
+	return cache
+}
+
+func saveCache(cache map[string]cacheEntry) {
+	path := cachePath()
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return
+	}
+	os.WriteFile(path, data, 0644)
+}
+
+// isShorter checks if the file name of path is shorter than the file name of other, or if there is no other
+func isShorter(path string, other string) bool {
+	return other == "" || len(filepath.Base(path)) < len(filepath.Base(other))
+}
+
+func isFontFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".ttf" || ext == ".otf" || ext == ".ttc"
+}
+
+func stripExt(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// Names returns the family and full names of the font at path, without duplicates
+func Names(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := findTable(data, "name")
+	if err != nil {
+		return nil, err
+	}
+	if len(name) < 6 {
+		return nil, errInvalidFont
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	count := int(binary.BigEndian.Uint16(name[2:4]))
+	storage := int(binary.BigEndian.Uint16(name[4:6]))
+	for i := 0; i < count; i++ {
+		record := 6 + i*12
+		if record+12 > len(name) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(name[record:])
+		nameID := binary.BigEndian.Uint16(name[record+6:])
+		length := int(binary.BigEndian.Uint16(name[record+8:]))
+		offset := storage + int(binary.BigEndian.Uint16(name[record+10:]))
+
+		// 1 is the family name, 4 is the full name, and 16 is the typographic family name
+		if nameID != 1 && nameID != 4 && nameID != 16 {
+			continue
+		}
+		if offset+length > len(name) {
+			continue
+		}
+
+		var value string
+		switch platform {
+		case 0, 3:
+			// unicode and windows names are UTF-16
+			raw := name[offset : offset+length]
+			units := make([]uint16, len(raw)/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(raw[j*2:])
+			}
+			value = string(utf16.Decode(units))
+		case 1:
+			value = string(name[offset : offset+length])
+		default:
+			continue
+		}
+
+		if value != "" && !seen[value] {
+			seen[value] = true
+			names = append(names, value)
+		}
+	}
+
+	return names, nil
+}
+
+// Covers checks if the font at path has a glyph for every rune in text, ignoring whitespace and control characters
+func Covers(path string, text string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	cmap, err := findCmap(data)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range text {
+		if r <= ' ' || r == 0x7f {
+			continue
+		}
+		if !cmap.has(r) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Metrics is how wide each character of a font is, for measuring text without drawing it
+type Metrics struct {
+	cmap        cmapTable
+	hmtx        []byte
+	numHMetrics int
+	unitsPerEm  float64
+}
+
+// LoadMetrics reads the character widths of the font at path
+func LoadMetrics(path string) (Metrics, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Metrics{}, err
+	}
+
+	cmap, err := findCmap(data)
+	if err != nil {
+		return Metrics{}, err
+	}
+	head, err := findTable(data, "head")
+	if err != nil {
+		return Metrics{}, err
+	}
+	hhea, err := findTable(data, "hhea")
+	if err != nil {
+		return Metrics{}, err
+	}
+	hmtx, err := findTable(data, "hmtx")
+	if err != nil {
+		return Metrics{}, err
+	}
+	if len(head) < 20 || len(hhea) < 36 {
+		return Metrics{}, errInvalidFont
+	}
+
+	unitsPerEm := binary.BigEndian.Uint16(head[18:20])
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:36]))
+	if unitsPerEm == 0 || numHMetrics == 0 || numHMetrics*4 > len(hmtx) {
+		return Metrics{}, errInvalidFont
+	}
+
+	return Metrics{cmap: cmap, hmtx: hmtx, numHMetrics: numHMetrics, unitsPerEm: float64(unitsPerEm)}, nil
+}
+
+// Width returns how wide text is in ems, so it can be multiplied by the font size to get pixels
+// characters the font doesn't have are measured as its missing character box, since that's what gets drawn
+func (m Metrics) Width(text string) float64 {
+	var width int
+	for _, r := range text {
+		// glyphs past the last metric all have the same width as it
+		glyph := int(m.cmap.glyph(r))
+		if glyph >= m.numHMetrics {
+			glyph = m.numHMetrics - 1
+		}
+		width += int(binary.BigEndian.Uint16(m.hmtx[glyph*4:]))
+	}
+	return float64(width) / m.unitsPerEm
+}
+
+// findTable finds a table in a ttf, otf, or ttc (only the first font of a collection is used, since that's the one ffmpeg uses)
+func findTable(data []byte, tag string) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, errInvalidFont
+	}
+
+	offset := 0
+	if string(data[0:4]) == "ttcf" {
+		if len(data) < 16 {
+			return nil, errInvalidFont
+		}
+		offset = int(binary.BigEndian.Uint32(data[12:16]))
+	}
+	if offset+12 > len(data) {
+		return nil, errInvalidFont
+	}
+
+	numTables := int(binary.BigEndian.Uint16(data[offset+4 : offset+6]))
+	for i := 0; i < numTables; i++ {
+		record := offset + 12 + i*16
+		if record+16 > len(data) {
+			return nil, errInvalidFont
+		}
+		if string(data[record:record+4]) == tag {
+			start := int(binary.BigEndian.Uint32(data[record+8 : record+12]))
+			if start > len(data) {
+				return nil, errInvalidFont
+			}
+			return data[start:], nil
+		}
+	}
+
+	return nil, errInvalidFont
+}
+
+type cmapTable struct {
+	data   []byte
+	format uint16
+}
+
+// findCmap finds the best unicode cmap subtable
+func findCmap(data []byte) (cmapTable, error) {
+	cmap, err := findTable(data, "cmap")
+	if err != nil {
+		return cmapTable{}, err
+	}
+	if len(cmap) < 4 {
+		return cmapTable{}, errInvalidFont
+	}
+
+	// prefer a full unicode (format 12) subtable, then a BMP (format 4) one
+	var best cmapTable
+	numSubtables := int(binary.BigEndian.Uint16(cmap[2:4]))
+	for i := 0; i < numSubtables; i++ {
+		record := 4 + i*8
+		if record+8 > len(cmap) {
+			return cmapTable{}, errInvalidFont
+		}
+		platform := binary.BigEndian.Uint16(cmap[record : record+2])
+		encoding := binary.BigEndian.Uint16(cmap[record+2 : record+4])
+		if platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+
+		start := int(binary.BigEndian.Uint32(cmap[record+4 : record+8]))
+		if start+2 > len(cmap) {
+			continue
+		}
+		format := binary.BigEndian.Uint16(cmap[start : start+2])
+		if format == 12 || (format == 4 && best.format != 12) {
+			best = cmapTable{data: cmap[start:], format: format}
+		}
+	}
+	if best.data == nil {
+		return cmapTable{}, errInvalidFont
+	}
+
+	return best, nil
+}
+
+// has checks if the cmap subtable maps r to a glyph
+func (c cmapTable) has(r rune) bool {
+	return c.glyph(r) != 0
+}
+
+// glyph finds the glyph that the cmap subtable maps r to, which is 0 (the missing character glyph) if there isn't one
+func (c cmapTable) glyph(r rune) uint16 {
+	switch c.format {
+	case 4:
+		if r > 0xffff || len(c.data) < 14 {
+			return 0
+		}
+		segCount := int(binary.BigEndian.Uint16(c.data[6:8])) / 2
+		endCodes := 14
+		startCodes := endCodes + segCount*2 + 2
+		idDeltas := startCodes + segCount*2
+		idRangeOffsets := idDeltas + segCount*2
+		if idRangeOffsets+segCount*2 > len(c.data) {
+			return 0
+		}
+
+		for i := 0; i < segCount; i++ {
+			end := rune(binary.BigEndian.Uint16(c.data[endCodes+i*2:]))
+			if r > end {
+				continue
+			}
+			start := rune(binary.BigEndian.Uint16(c.data[startCodes+i*2:]))
+			if r < start {
+				return 0
+			}
+
+			delta := binary.BigEndian.Uint16(c.data[idDeltas+i*2:])
+			rangeOffset := int(binary.BigEndian.Uint16(c.data[idRangeOffsets+i*2:]))
+			if rangeOffset == 0 {
+				return uint16(r) + delta
+			}
+
+			glyphIndex := idRangeOffsets + i*2 + rangeOffset + int(r-start)*2
+			if glyphIndex+2 > len(c.data) {
+				return 0
+			}
+			glyph := binary.BigEndian.Uint16(c.data[glyphIndex:])
+			if glyph == 0 {
+				return 0
+			}
+			return glyph + delta
+		}
+	case 12:
+		if len(c.data) < 16 {
+			return 0
+		}
+		numGroups := int(binary.BigEndian.Uint32(c.data[12:16]))
+		for i := 0; i < numGroups; i++ {
+			group := 16 + i*12
+			if group+12 > len(c.data) {
+				return 0
+			}
+			start := rune(binary.BigEndian.Uint32(c.data[group:]))
+			end := rune(binary.BigEndian.Uint32(c.data[group+4:]))
+			if r >= start && r <= end {
+				return uint16(binary.BigEndian.Uint32(c.data[group+8:]) + uint32(r-start))
+			}
+		}
+	}
+	return 0
+}
@@ -20,21 +20,25 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image/color"
 	"io/ioutil"
 	"log"
 	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"qm-go/ffprobe"
+	"qm-go/fonts"
 	"qm-go/utils"
 
-	"github.com/flopp/go-findfont"
 	"github.com/spf13/pflag"
 	"golang.org/x/term"
 )
@@ -51,31 +55,92 @@ var (
 	updateSpeed               float64
 	noVideo, noAudio          bool
 	replaceAudio              string
+	mixAudio                  string
+	audioOffset, audioGain    float64
+	audioLoop                 bool
+	mixFadeIn, mixFadeOut     float64
 	preset                    int
 	start, end, outDuration   float64
 	volume                    int
-	earrape                   bool
+	earrape                   int
+	limit                     float64
+	echo, reverb              string
+	audioStutter              int
+	noiseBed                  string
+	noiseLevel                int
+	bassBoost                 float64
+	bassCurve                 string
+	bassDistort               bool
+	bitcrush, sampleRate      int
+	mono                      bool
+	audioVoice                string
 	outScale                  float64
+	pixelate                  bool
+	upscaleTo                 string
 	videoBrDiv, audioBrDiv    int
 	stretch                   string
 	outFPS                    int
 	speed                     float64
+	pitch                     float64
+	chipmunk                  bool
+	reverse                   bool
+	boomerang                 int
 	zoom                      float64
 	fadein, fadeout           float64
 	stutter                   int
 	vignette                  float64
 	corrupt                   int
 	fry                       int
+	flip, mirror              string
+	rotate                    float64
+	rotateFill                string
+	shake                     int
+	film                      int
+	rgbSplit                  int
+	rgbSplitAngle             float64
+	rgbSplitJitter            bool
+	seed                      int
+	vhs                       int
+	crt                       int
+	palette, dither           string
+	paletteColorCount         int
 	interlace                 bool
 	lagfun                    bool
 	resample                  bool
-	text, textFont, textColor string
-	textposx, textposy        int
-	fontSize                  float64
+	texts, textFonts          []string
+	textColors                []string
+	textposx, textposy        []int
+	fontSizes                 []float64
+	textStarts, textEnds      []float64
+	lineSpacings              []float64
+	textFiles                 []string
+	textRecipe                string
+	fallbackFonts             []string
+	textBoxes                 []bool
+	textBoxColors             []string
+	textShadows               []float64
+	textBorderColors          []string
+	textAligns                []string
+	textAngles                []float64
+	topText, bottomText       string
+	captionStyle, captionFont string
+	overlayFiles              []string
+	overlayposx, overlayposy  []int
+	overlayScales             []float64
+	overlayOpacities          []float64
+	overlayStarts             []float64
+	overlayEnds               []float64
+	subtitles                 string
+	subtitleStream            int
 
 	// other variables
 	unspecifiedProgbarSize bool
 	strFmt                 formats
+	textOverlays           []textOverlay
+	imageOverlays          []imageOverlay
+	paletteColors          []color.RGBA
+	labelCount             int
+	tempFontCount          int
 )
 
 type formats struct {
@@ -118,13 +183,35 @@ func init() {
 	pflag.BoolVar(&noVideo, "no-video", false, "Produces an output with no video")
 	pflag.BoolVar(&noAudio, "no-audio", false, "Produces an output with no audio")
 	pflag.StringVar(&replaceAudio, "replace-audio", "", "Replace the audio with the specified file")
+	pflag.StringVar(&mixAudio, "mix-audio", "", "Mix the specified file under the audio")
+	pflag.Float64Var(&audioOffset, "audio-offset", 0, "Seconds into the clip to start --mix-audio (negative skips the start of --mix-audio instead)")
+	pflag.BoolVar(&audioLoop, "audio-loop", false, "Loop --mix-audio until the clip ends")
+	pflag.Float64Var(&audioGain, "audio-gain", 0, "Amount to increase or decrease the volume of --mix-audio by, in dB")
+	pflag.Float64Var(&mixFadeIn, "mix-fade-in", 0, "Fade in duration for --mix-audio")
+	pflag.Float64Var(&mixFadeOut, "mix-fade-out", 0, "Fade out duration for --mix-audio")
 	pflag.IntVarP(&preset, "preset", "p", 4, "Specify the quality preset (1-7, higher = worse)")
 	pflag.Float64Var(&start, "start", 0, "Specify the start time of the output")
 	pflag.Float64Var(&end, "end", -1, "Specify the end time of the output, cannot be used when duration is specified")
 	pflag.Float64Var(&outDuration, "duration", -1, "Specify the duration of the output, cannot be used when end is specified")
 	pflag.IntVarP(&volume, "volume", "v", 0, "Specify the amount to increase or decrease the volume by, in dB")
-	pflag.BoolVar(&earrape, "earrape", false, "Heavily and extremely distort the audio (aka earrape). BE WARNED: VOLUME WILL BE SUBSTANTIALLY INCREASED.")
+	pflag.IntVar(&earrape, "earrape", 0, "Heavily and extremely distort the audio (aka earrape) (1-10, higher = worse, 10 if no level is given). BE WARNED: VOLUME WILL BE SUBSTANTIALLY INCREASED.")
+	pflag.Lookup("earrape").NoOptDefVal = "10"
+	pflag.Float64Var(&bassBoost, "bass-boost", 0, "Boost the bass by this many dB")
+	pflag.StringVar(&bassCurve, "bass-curve", "shelf", "Which bass to boost: shelf (everything low), sub (just the sub bass), or wide (most of the low end)")
+	pflag.BoolVar(&bassDistort, "bass-distort", false, "Distort the audio after boosting the bass")
+	pflag.StringVar(&echo, "echo", "", "Add an echo to the audio: room or cave")
+	pflag.StringVar(&reverb, "reverb", "", "Add reverb to the audio: room or cave")
+	pflag.IntVar(&audioStutter, "audio-stutter", 0, "Repeat short slices of the audio at random, like --stutter for the audio (higher = more stutter, uses --seed)")
+	pflag.StringVar(&noiseBed, "noise-bed", "", "Mix noise under the audio: crackle, hiss, hum, or static")
+	pflag.IntVar(&noiseLevel, "noise-level", 5, "How loud --noise-bed is (1-10)")
+	pflag.Float64Var(&limit, "limit", 0, "Keep the audio's peaks under this many dB, applied after every other audio filter (-24 to 0, 0 = off)")
+	pflag.IntVar(&bitcrush, "bitcrush", -1, "Bit depth to crush the audio to (1-32, 0 = off), defaults based on the preset")
+	pflag.IntVar(&sampleRate, "sample-rate", -1, "Sample rate to lower the audio to, in Hz (0 = keep the input's), defaults based on the preset")
+	pflag.BoolVar(&mono, "mono", false, "Downmix the audio to mono")
+	pflag.StringVar(&audioVoice, "audio-voice", "", "Make the audio sound like it's coming from a phone, radio, walkie, or megaphone")
 	pflag.Float64VarP(&outScale, "scale", "s", -1, "Specify the output scale")
+	pflag.BoolVar(&pixelate, "pixelate", false, "Scale the output back up to the input size without smoothing it, so it's blocky instead of small (same as --upscale-to source)")
+	pflag.StringVar(&upscaleTo, "upscale-to", "", "Scale the output back up without smoothing it to either the input size (source) or a size (WxH)")
 	pflag.IntVar(&videoBrDiv, "video-bitrate", -1, "Specify the video bitrate divisor (higher = worse)")
 	pflag.IntVar(&videoBrDiv, "vb", videoBrDiv, "Shorthand for --video-bitrate")
 	pflag.IntVar(&audioBrDiv, "audio-bitrate", -1, "Specify the audio bitrate divisor (higher = worse)")
@@ -132,6 +219,11 @@ func init() {
 	pflag.StringVar(&stretch, "stretch", "1:1", "Modify the existing aspect ratio")
 	pflag.IntVar(&outFPS, "fps", -1, "Specify the output fps (lower = worse)")
 	pflag.Float64Var(&speed, "speed", 1.0, "Specify the video and audio speed")
+	pflag.Float64Var(&pitch, "pitch", 0, "Change the pitch of the audio by this many semitones without changing its speed (-12 to 12)")
+	pflag.BoolVar(&chipmunk, "chipmunk", false, "Change the pitch of the audio along with --speed, like speeding up a tape")
+	pflag.BoolVar(&reverse, "reverse", false, "Play the output backwards")
+	pflag.IntVar(&boomerang, "boomerang", 0, "Play the output forward then backward this many times (1 if no count is given, backward first with --reverse)")
+	pflag.Lookup("boomerang").NoOptDefVal = "1"
 	pflag.Float64VarP(&zoom, "zoom", "z", 1, "Specify the amount to zoom in or out")
 	pflag.Float64Var(&fadein, "fade-in", 0, "Fade in duration")
 	pflag.Float64Var(&fadeout, "fade-out", 0, "Fade out duration")
@@ -139,17 +231,74 @@ func init() {
 	pflag.Float64Var(&vignette, "vignette", 0, "Specify the amount of vignette")
 	pflag.IntVar(&corrupt, "corrupt", 0, "Corrupt the output (1-10, higher = worse)")
 	pflag.IntVar(&fry, "deep-fry", 0, "Deep-fry the output (1-10, higher = worse)")
+	pflag.StringVar(&flip, "flip", "", "Flip the output horizontally (h), vertically (v), or both")
+	pflag.StringVar(&mirror, "mirror", "", "Reflect part of the output over the rest: left, right, top, bottom, or quad (kaleidoscope)")
+	pflag.Float64Var(&rotate, "rotate", 0, "Rotate the output by this many degrees")
+	pflag.StringVar(&rotateFill, "rotate-fill", "black", "Color to fill the corners with when rotating")
+	pflag.IntVar(&shake, "shake", 0, "Shake the output by up to this many pixels every frame (uses --seed)")
+	pflag.IntVar(&film, "film", 0, "Make the output look like old film, with dust, scratches, and flicker (1-10, higher = worse, uses --seed)")
+	pflag.IntVar(&rgbSplit, "rgb-split", 0, "Split the red, green, and blue colors apart by this many pixels")
+	pflag.Float64Var(&rgbSplitAngle, "rgb-split-angle", 0, "Direction to split the colors in, in degrees (0 is horizontal, 90 is vertical)")
+	pflag.BoolVar(&rgbSplitJitter, "rgb-split-jitter", false, "Move the split colors a random amount every frame, up to --rgb-split")
+	pflag.IntVar(&seed, "seed", 0, "Seed for random effects, so they come out the same every time")
+	pflag.IntVar(&vhs, "vhs", 0, "Make the output look and sound like an old VHS tape (1-10, higher = worse)")
+	pflag.StringVar(&palette, "palette", "", "Reduce the colors to a palette: gameboy, cga, ega, 1bit, websafe, a list of hex colors, or an image to take the colors from")
+	pflag.IntVar(&paletteColorCount, "palette-colors", 16, "Number of colors to take from the image when --palette is an image")
+	pflag.StringVar(&dither, "dither", "bayer", "Dithering to use with --palette (none, bayer, floyd_steinberg, or sierra)")
+	pflag.IntVar(&crt, "crt", 0, "Make the output look like it's on a CRT, with scanlines and a curved screen (1-10, higher = stronger), this uses --vignette")
 	pflag.BoolVar(&interlace, "interlace", false, "Interlace the output")
 	pflag.BoolVar(&lagfun, "lagfun", false, "Force darker pixels to update slower")
 	pflag.BoolVar(&resample, "resample", false, "Blend frames together instead of dropping them")
-	pflag.StringVarP(&text, "text", "t", "", "Text to add (if empty, no text)")
-	pflag.StringVar(&textFont, "text-font", "arial", "Text to add (if empty, no text)")
-	pflag.StringVar(&textColor, "text-color", "white", "Text color")
-	pflag.IntVar(&textposx, "text-pos-x", 50, "horizontal position of text (0 is far left, 100 is far right)")
-	pflag.IntVar(&textposy, "text-pos-y", 90, "vertical position of text (0 is top, 100 is bottom)")
-	pflag.Float64Var(&fontSize, "font-size", 12, "Font size (scales with output width)")
+	pflag.StringArrayVarP(&texts, "text", "t", []string{}, "Text to add, can be used multiple times (if empty, no text)")
+	pflag.StringArrayVar(&textFonts, "text-font", []string{"arial"}, "Text font, one per --text (the last one is used for any extra text)")
+	pflag.StringArrayVar(&textColors, "text-color", []string{"white"}, "Text color, one per --text")
+	pflag.IntSliceVar(&textposx, "text-pos-x", []int{50}, "horizontal position of text, one per --text (0 is far left, 100 is far right)")
+	pflag.IntSliceVar(&textposy, "text-pos-y", []int{90}, "vertical position of text, one per --text (0 is top, 100 is bottom)")
+	pflag.Float64SliceVar(&fontSizes, "font-size", []float64{12}, "Font size, one per --text (scales with output width)")
+	pflag.Float64SliceVar(&textStarts, "text-start", []float64{0}, "Time to start showing text, one per --text")
+	pflag.Float64SliceVar(&textEnds, "text-end", []float64{-1}, "Time to stop showing text, one per --text (-1 shows it until the end)")
+	pflag.Float64SliceVar(&lineSpacings, "line-spacing", []float64{0}, "Space between lines of text, one per --text (scales with output width)")
+	pflag.StringArrayVar(&textFiles, "text-file", []string{}, "File with text to add, can be used multiple times (counted after every --text for the other text flags)")
+	pflag.StringVar(&textRecipe, "text-recipe", "", "JSON file with a list of text overlays, used in addition to --text")
+	pflag.BoolSliceVar(&textBoxes, "text-box", []bool{false}, "Draw a box behind the text, one per --text")
+	pflag.Lookup("text-box").NoOptDefVal = "true"
+	pflag.StringArrayVar(&textBoxColors, "text-box-color", []string{"black@0.5"}, "Color of the box behind the text, one per --text")
+	pflag.Float64SliceVar(&textShadows, "text-shadow", []float64{0}, "Distance of the text's drop shadow, one per --text (scales with output width)")
+	pflag.StringArrayVar(&textBorderColors, "text-border-color", []string{"black"}, "Color of the text's border, one per --text")
+	pflag.StringArrayVar(&textAligns, "text-align", []string{"left"}, "Alignment of multiline text (left, center, or right, needs ffmpeg 6.1 or newer), one per --text")
+	pflag.Float64SliceVar(&textAngles, "text-angle", []float64{0}, "Angle to rotate the text clockwise by in degrees, one per --text")
+	pflag.StringSliceVar(&fallbackFonts, "fallback-fonts", []string{"seguiemj", "seguisym", "NotoEmoji", "NotoSansCJK", "msyh", "DroidSansFallback", "Arial Unicode"}, "Fonts to use, in order, when a font is missing characters in the text (such as emoji or CJK), the whole text uses the first one that has every character in it")
+	pflag.StringVar(&topText, "top-text", "", "Caption to add to the top of the output (if empty, no caption)")
+	pflag.StringVar(&bottomText, "bottom-text", "", "Caption to add to the bottom of the output (if empty, no caption)")
+	pflag.StringVar(&captionStyle, "caption-style", "impact", "Caption style (impact or bar)")
+	pflag.StringVar(&captionFont, "caption-font", "impact", "Caption font")
+	pflag.StringArrayVar(&overlayFiles, "overlay", []string{}, "Image or GIF to put on top of the output, can be used multiple times")
+	pflag.IntSliceVar(&overlayposx, "overlay-pos-x", []int{50}, "horizontal position of the overlay, one per --overlay (0 is far left, 100 is far right)")
+	pflag.IntSliceVar(&overlayposy, "overlay-pos-y", []int{50}, "vertical position of the overlay, one per --overlay (0 is top, 100 is bottom)")
+	pflag.Float64SliceVar(&overlayScales, "overlay-scale", []float64{0.25}, "Width of the overlay compared to the output width, one per --overlay")
+	pflag.Float64SliceVar(&overlayOpacities, "overlay-opacity", []float64{1}, "Opacity of the overlay (0-1), one per --overlay")
+	pflag.Float64SliceVar(&overlayStarts, "overlay-start", []float64{0}, "Time to start showing the overlay, one per --overlay")
+	pflag.Float64SliceVar(&overlayEnds, "overlay-end", []float64{-1}, "Time to stop showing the overlay, one per --overlay (-1 shows it until the end)")
+	pflag.StringVar(&subtitles, "subtitles", "", "SRT or ASS subtitle file to burn into the output")
+	pflag.IntVar(&subtitleStream, "subtitle-stream", -1, "Index of the subtitle stream to burn in, from --subtitles or from the input if --subtitles isn't used")
+}
+
+// parseFlags parses the flags registered in init and checks them, which is left to main so tests can run without any
+func parseFlags() {
 	pflag.Parse()
 
+	// qm fonts lists the fonts that can be used instead of encoding anything
+	if pflag.Arg(0) == "fonts" {
+		listFonts()
+		os.Exit(0)
+	}
+
+	// flags with an optional value (like --earrape and --boomerang) only take it after an =, so anything else left over
+	// is most likely a value that was meant for one of them
+	if pflag.NArg() > 0 {
+		log.Fatal("Unexpected argument ", pflag.Arg(0), " (values for --earrape and --boomerang have to be given like --earrape=3)")
+	}
+
 	// check for invalid input
 	if inputs[0] == "" {
 		log.Fatal("No input was specified")
@@ -165,6 +314,161 @@ func init() {
 	if outDuration != -1 && end != -1 {
 		log.Fatal("Cannot specify both duration and end time")
 	}
+	if flip != "" && flip != "h" && flip != "v" && flip != "both" {
+		log.Fatal("Flip must be h, v, or both")
+	}
+	if mirror != "" && !mirrorModes[mirror] {
+		log.Fatal("Mirror must be left, right, top, bottom, or quad")
+	}
+	if shake < 0 {
+		log.Fatal("Shake cannot be negative")
+	}
+	if film < 0 || film > 10 {
+		log.Fatal("Film level must be between 0 and 10")
+	}
+	if rgbSplit < 0 {
+		log.Fatal("RGB split cannot be negative")
+	}
+	if speed <= 0 {
+		log.Fatal("Speed must be greater than 0")
+	}
+	if boomerang < 0 {
+		log.Fatal("Boomerang count cannot be negative")
+	}
+	if pitch < -12 || pitch > 12 {
+		log.Fatal("Pitch must be between -12 and 12 semitones")
+	}
+	if chipmunk && speed == 1 {
+		fmt.Println(strFmt.warning + "Warning: --chipmunk does nothing without --speed" + strFmt.reset)
+	}
+	if earrape < 0 || earrape > 10 {
+		log.Fatal("Earrape level must be between 0 and 10")
+	}
+	if bassBoost < 0 {
+		log.Fatal("Bass boost cannot be negative")
+	}
+	if _, ok := bassCurves[bassCurve]; !ok {
+		log.Fatal("Bass curve must be shelf, sub, or wide")
+	}
+	if _, ok := echoPresets[echo]; echo != "" && !ok {
+		log.Fatal("Echo must be room or cave")
+	}
+	if _, ok := reverbPresets[reverb]; reverb != "" && !ok {
+		log.Fatal("Reverb must be room or cave")
+	}
+	if audioStutter < 0 {
+		log.Fatal("Audio stutter cannot be negative")
+	}
+	if _, ok := noiseBeds[noiseBed]; noiseBed != "" && !ok {
+		log.Fatal("Noise bed must be crackle, hiss, hum, or static")
+	}
+	if noiseLevel < 1 || noiseLevel > 10 {
+		log.Fatal("Noise level must be between 1 and 10")
+	}
+	if mixAudio != "" {
+		if _, err := os.Stat(mixAudio); err != nil {
+			log.Fatal("Unable to find the audio to mix in: ", err)
+		}
+	}
+	if mixFadeIn < 0 || mixFadeOut < 0 {
+		log.Fatal("Mix fade durations cannot be negative")
+	}
+	if limit < -24 || limit > 0 {
+		log.Fatal("Limit must be between -24 and 0 dB")
+	}
+	if preset < 1 || preset > 7 {
+		log.Fatal("Preset must be between 1 and 7")
+	}
+	if bitcrush < -1 || bitcrush > 32 {
+		log.Fatal("Bitcrush must be between 1 and 32, or 0 to turn it off")
+	}
+	if sampleRate != -1 && sampleRate != 0 && sampleRate < 1000 {
+		log.Fatal("Sample rate must be at least 1000 Hz, or 0 to keep the input's")
+	}
+	if _, ok := audioVoices[audioVoice]; audioVoice != "" && !ok {
+		log.Fatal("Audio voice must be phone, radio, walkie, or megaphone")
+	}
+	if vhs < 0 || vhs > 10 {
+		log.Fatal("VHS level must be between 0 and 10")
+	}
+	if crt < 0 || crt > 10 {
+		log.Fatal("CRT level must be between 0 and 10")
+	}
+	if palette != "" {
+		// images are handled by ffmpeg, everything else is turned into a list of colors now
+		if _, err := os.Stat(palette); err != nil {
+			var err error
+			paletteColors, err = parsePalette(palette)
+			if err != nil {
+				log.Fatal("Invalid palette: ", err)
+			}
+		}
+		if _, ok := ditherModes[dither]; !ok {
+			log.Fatal("Dither must be none, bayer, floyd_steinberg, or sierra")
+		}
+		if paletteColorCount < 2 || paletteColorCount > 256 {
+			log.Fatal("Palette colors must be between 2 and 256")
+		}
+	}
+	if pixelate && upscaleTo == "" {
+		upscaleTo = "source"
+	}
+	if upscaleTo != "" && upscaleTo != "source" {
+		if _, _, err := parseSize(upscaleTo); err != nil {
+			log.Fatal("Upscale size must be source or WxH: ", err)
+		}
+	}
+	if captionStyle != "impact" && captionStyle != "bar" {
+		log.Fatal("Caption style must be either impact or bar")
+	}
+
+	// gather all of the text overlays from the flags and the recipe file
+	for _, textFile := range textFiles {
+		fileText, err := ioutil.ReadFile(textFile)
+		if err != nil {
+			log.Fatal("Unable to read text file: ", err)
+		}
+		texts = append(texts, strings.TrimRight(string(fileText), "\r\n"))
+	}
+	textOverlays = textFlagOverlays()
+	if textRecipe != "" {
+		recipeOverlays, err := readTextRecipe(textRecipe)
+		if err != nil {
+			log.Fatal("Unable to read text recipe: ", err)
+		}
+		textOverlays = append(textOverlays, recipeOverlays...)
+	}
+	for _, overlay := range textOverlays {
+		if overlay.End != -1 && overlay.Start >= overlay.End {
+			log.Fatal("Text start time cannot be greater than or equal to text end time")
+		}
+		if overlay.Align != "left" && overlay.Align != "center" && overlay.Align != "right" {
+			log.Fatal("Text alignment must be left, center, or right")
+		}
+	}
+
+	if subtitles != "" {
+		if _, err := os.Stat(subtitles); err != nil {
+			log.Fatal("Subtitle file ", subtitles, " does not exist")
+		}
+	}
+	// the subtitles are timed for the input playing forward, so they'd be shown at the wrong times
+	if (subtitles != "" || subtitleStream != -1) && (reverse || boomerang != 0) {
+		log.Fatal("Subtitles can't be burned in with --reverse or --boomerang")
+	}
+
+	imageOverlays = imageFlagOverlays()
+	for _, overlay := range imageOverlays {
+		if _, err := os.Stat(overlay.Path); err != nil {
+			log.Fatal("Overlay file ", overlay.Path, " does not exist")
+		}
+		if overlay.End != -1 && overlay.Start >= overlay.End {
+			log.Fatal("Overlay start time cannot be greater than or equal to overlay end time")
+		}
+		if overlay.Scale <= 0 {
+			log.Fatal("Overlay scale must be greater than 0")
+		}
+	}
 
 	// make sure that we know when the progress bar length is unspecified so we can set it automatically
 	if progbarLength == -1 {
@@ -175,6 +479,8 @@ func init() {
 }
 
 func main() {
+	parseFlags()
+
 	// throw out all flags if debug is enabled
 	if debug {
 		log.Println("throwing all flags out")
@@ -188,32 +494,99 @@ func main() {
 			updateSpeed,
 			noVideo,
 			noAudio,
+			replaceAudio,
+			mixAudio,
+			audioOffset,
+			audioLoop,
+			audioGain,
+			mixFadeIn,
+			mixFadeOut,
 			preset,
 			start,
 			end,
 			outDuration,
 			volume,
+			earrape,
+			bassBoost,
+			bassCurve,
+			bassDistort,
+			echo,
+			reverb,
+			audioStutter,
+			noiseBed,
+			noiseLevel,
+			limit,
+			bitcrush,
+			sampleRate,
+			mono,
+			audioVoice,
 			outScale,
+			pixelate,
+			upscaleTo,
 			videoBrDiv,
 			audioBrDiv,
 			stretch,
 			outFPS,
 			speed,
+			pitch,
+			chipmunk,
+			reverse,
+			boomerang,
 			zoom,
 			fadein,
 			fadeout,
 			stutter,
 			vignette,
 			corrupt,
+			flip,
+			mirror,
+			rotate,
+			rotateFill,
+			shake,
+			film,
+			rgbSplit,
+			rgbSplitAngle,
+			rgbSplitJitter,
+			seed,
+			vhs,
+			crt,
+			palette,
+			paletteColorCount,
+			dither,
 			interlace,
 			lagfun,
 			resample,
-			text,
-			textFont,
-			textColor,
+			texts,
+			textFonts,
+			textColors,
 			textposx,
 			textposy,
-			fontSize,
+			fontSizes,
+			textStarts,
+			textEnds,
+			lineSpacings,
+			textFiles,
+			textRecipe,
+			fallbackFonts,
+			textBoxes,
+			textBoxColors,
+			textShadows,
+			textBorderColors,
+			textAligns,
+			textAngles,
+			topText,
+			bottomText,
+			captionStyle,
+			captionFont,
+			overlayFiles,
+			overlayposx,
+			overlayposy,
+			overlayScales,
+			overlayOpacities,
+			overlayStarts,
+			overlayEnds,
+			subtitles,
+			subtitleStream,
 		)
 	}
 
@@ -251,7 +624,8 @@ func main() {
 		}
 		if len(string(replaceAudio)) == 0 {
 			if !noAudio {
-				renderAudio = Stream(input, "a:0")
+				// if there's audio to mix in, it's used even if the input doesn't have audio
+				renderAudio = Stream(input, "a:0") || mixAudio != ""
 			}
 		}
 
@@ -381,15 +755,21 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		log.Print("Output scale is ", outScale)
 	}
 
-	// calculate the output resolution
+	// calculate the output resolution, the resolution the filters leave it at, and the resolution it ends up at if it's scaled back up
 	outputWidth, outputHeight := newResolution(inputData.Width, inputData.Height)
+	var caption captionLayout
+	if renderVideo && (topText != "" || bottomText != "") {
+		caption = layoutCaption(outputWidth, outputHeight, topText, bottomText, captionFont, captionStyle)
+	}
+	frameWidth, frameHeight := filteredResolution(outputWidth, outputHeight, caption)
+	finalWidth, finalHeight := upscaleResolution(inputData.Width, inputData.Height, outputWidth, outputHeight, frameWidth, frameHeight)
 
 	var bitrate int
-	// calculate the video bitrate
+	// calculate the video bitrate, at the final size since that's what actually gets encoded
 	if videoBrDiv != -1 {
-		bitrate = outputHeight * outputWidth * int(math.Sqrt(float64(outFPS))) / videoBrDiv
+		bitrate = finalHeight * finalWidth * int(math.Sqrt(float64(outFPS))) / videoBrDiv
 	} else {
-		bitrate = outputHeight * outputWidth * int(math.Sqrt(float64(outFPS))) / preset
+		bitrate = finalHeight * finalWidth * int(math.Sqrt(float64(outFPS))) / preset
 	}
 
 	var audioBitrate int
@@ -400,12 +780,37 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		audioBitrate = 80000 / preset
 	}
 
+	// if the sample rate and bit depth aren't explicitly set, get them from the preset
+	if sampleRate == -1 {
+		sampleRate = presetSampleRate()
+	}
+	if bitcrush == -1 {
+		bitcrush = presetBitcrush()
+	}
+
 	if debug {
-		log.Print("bitrate is ", bitrate, " which i got by doing ", outputHeight, "*", outputWidth, "*", int(math.Sqrt(float64(outFPS))), "/", preset)
+		log.Print("bitrate is ", bitrate, " which i got by doing ", finalHeight, "*", finalWidth, "*", int(math.Sqrt(float64(outFPS))), "/", preset)
 	}
 
-	// set up the ffmpeg filter for -filter_complex
+	// set up the ffmpeg filters for -filter_complex, which are kept separate until the end so their inputs and outputs can be labelled
 	var filter strings.Builder
+	var audioFilter strings.Builder
+
+	// the input is always first, then the replacement audio, then the audio to mix in, then the overlays
+	audioInput := "0:a:0"
+	overlayInput := 1
+	if replaceAudio != "" {
+		audioInput = "1:a:0"
+		overlayInput = 2
+	}
+	mixInput := overlayInput
+	if mixAudio != "" {
+		overlayInput++
+	}
+	paletteInput := overlayInput + len(imageOverlays)
+
+	// without replacement audio, the input might only have audio because of --mix-audio
+	hasAudio := replaceAudio != "" || (renderAudio && Stream(input, "a:0"))
 
 	// if NOT using --no-video, set add the specified video filters to filter
 	if renderVideo {
@@ -439,15 +844,32 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 			}
 		}
 
-		if vignette != 0 {
+		if flip != "" || mirror != "" || rotate != 0 || shake != 0 {
+			filter.WriteString(makeTransformFilter(outputWidth, outputHeight))
+		}
+
+		// the crt effect does its own vignette after curving the screen
+		if vignette != 0 && crt == 0 {
 			filter.WriteString(",vignette=PI/(5/(" + strconv.FormatFloat(vignette, 'f', -1, 64) + "/2))")
 			if debug {
 				log.Print("vignette amount is ", vignette, " or PI/(5/("+strconv.FormatFloat(vignette, 'f', -1, 64)+"/2))")
 			}
 		}
 
-		if text != "" {
-			filter.WriteString(makeTextFilter(outputWidth, text, textFont, fontSize, textColor, textposx, textposy))
+		if subtitles != "" || subtitleStream != -1 {
+			filter.WriteString(makeSubtitleFilter(input))
+		}
+
+		if len(imageOverlays) != 0 {
+			filter.WriteString(makeOverlayFilter(outputWidth, imageOverlays, overlayInput))
+		}
+
+		if len(textOverlays) != 0 {
+			filter.WriteString(makeTextFilter(outputWidth, outputHeight, textOverlays, input))
+		}
+
+		if topText != "" || bottomText != "" {
+			filter.WriteString(makeCaptionFilter(outputHeight, caption, captionStyle))
 		}
 
 		if interlace {
@@ -471,50 +893,130 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 				log.Print("fry is ", ","+"eq=saturation="+strconv.FormatFloat(float64(fry)*0.15+0.85, 'f', -1, 64)+":contrast="+strconv.Itoa(fry)+",unsharp=5:5:1.25:5:5:"+strconv.FormatFloat(float64(fry)/6.66, 'f', -1, 64)+",noise=alls="+strconv.Itoa(fry*5)+":allf=t")
 			}
 		}
+
+		if film != 0 {
+			filter.WriteString(makeFilmFilter(film, seed))
+		}
+
+		if rgbSplit != 0 {
+			filter.WriteString(makeRGBSplitFilter(rgbSplit, rgbSplitAngle, rgbSplitJitter, seed))
+		}
+
+		if vhs != 0 {
+			filter.WriteString(makeVHSFilter(outputWidth, outputHeight, vhs, input))
+		}
+
+		if palette != "" {
+			filter.WriteString(makePaletteFilter(paletteInput))
+		}
+
+		if crt != 0 {
+			filter.WriteString(makeCRTFilter(crt))
+		}
+
+		if upscaleTo != "" {
+			filter.WriteString(makeUpscaleFilter(finalWidth, finalHeight))
+		}
 	} else {
 		log.Print("no video, ignoring all video filters")
 	}
 
 	// find what the duration of the output should be for the progress bar, % completion, and ETA in stats
-	var realOutputDuration float64
-	if outDuration >= inputData.Duration || outDuration == -1 {
-		realOutputDuration = (inputData.Duration - start) / speed // if the output duration is longer than the input duration, set the output duration to the input duration times speed
-	} else {
-		realOutputDuration = outDuration / speed // if the output duration is shorter than the input duration, set the output duration to the output duration times speed
+	realOutputDuration := outputDuration(inputData.Duration)
+	if boomerang != 0 {
+		realOutputDuration *= float64(2 * boomerang)
 	}
 
 	// if not using --no-audio, set add the specified audio filters to filter
 	if renderAudio {
-		if earrape {
-			filter.WriteString(";aeval=sgn(val(5)):c=same")
+		// without audio of its own, the clip gets silence as long as it is for the added audio to be mixed into
+		// if its length isn't known there's nothing to line the added audio up with, so it's used as the audio as it is
+		if mixAudio != "" && !hasAudio {
+			if realOutputDuration > 0 {
+				audioInput = ""
+				audioFilter.WriteString(",anullsrc=r=48000:cl=stereo,atrim=end=" + strconv.FormatFloat(realOutputDuration*speed, 'f', -1, 64))
+			} else {
+				audioInput = strconv.Itoa(mixInput) + ":a:0"
+			}
+		}
+
+		if bassBoost != 0 {
+			audioFilter.WriteString(makeBassBoostFilter(bassBoost, bassCurve, bassDistort))
+		}
+
+		if earrape != 0 {
+			audioFilter.WriteString(makeEarrapeFilter(earrape))
+		}
+
+		if echo != "" {
+			audioFilter.WriteString("," + echoPresets[echo])
 			if debug {
-				log.Print("earrape is true")
+				log.Print("echo is ", echo)
 			}
 		}
 
-		if volume != 0 {
-			if earrape {
-				filter.WriteString(",volume=" + strconv.Itoa(volume) + "dB")
-			} else {
-				filter.WriteString(";volume=" + strconv.Itoa(volume) + "dB")
+		if reverb != "" {
+			audioFilter.WriteString("," + reverbPresets[reverb])
+			if debug {
+				log.Print("reverb is ", reverb)
 			}
+		}
+
+		if volume != 0 {
+			audioFilter.WriteString(",volume=" + strconv.Itoa(volume) + "dB")
 			if debug {
 				log.Print("volume is ", volume)
 			}
 		}
 
-		// is speed is not 1, set the audio speed to the specified speed
+		if audioVoice != "" {
+			audioFilter.WriteString(makeVoiceFilter(audioVoice))
+		}
+
+		if pitch != 0 {
+			audioFilter.WriteString(makePitchFilter(pitch))
+		}
+
+		// is speed is not 1, set the audio speed to the specified speed, changing the pitch too with --chipmunk
 		if speed != 1 {
-			if replaceAudio != "" {
-				filter.WriteString(";[1]atempo=" + strconv.FormatFloat(speed, 'f', -1, 64)) // if the audio is being replaced, use audio from second input
+			if chipmunk {
+				audioFilter.WriteString(makeTapeSpeedFilter(speed))
 			} else {
-				filter.WriteString(";[0]atempo=" + strconv.FormatFloat(speed, 'f', -1, 64)) // first input (video)
+				audioFilter.WriteString(makeTempoFilter(speed))
 			}
 
 			if debug {
 				log.Print("audio speed is ", speed)
 			}
 		}
+
+		// the added audio is mixed in after the speed and pitch are changed so it plays normally, but still gets munched
+		// by everything after this
+		if mixAudio != "" && audioInput != strconv.Itoa(mixInput)+":a:0" {
+			audioFilter.WriteString(makeMixAudioFilter(mixInput, realOutputDuration))
+		}
+
+		// the stutters are placed along the output, so this has to go after the speed is changed
+		if audioStutter != 0 {
+			audioFilter.WriteString(makeAudioStutterFilter(audioStutter, seed))
+		}
+
+		if mono || sampleRate != 0 || bitcrush != 0 {
+			audioFilter.WriteString(makeCrushFilter(mono, sampleRate, bitcrush))
+		}
+
+		if vhs != 0 {
+			audioFilter.WriteString(makeVHSAudioFilter(vhs))
+		}
+
+		if noiseBed != "" {
+			audioFilter.WriteString(makeNoiseBedFilter(noiseBed, noiseLevel))
+		}
+
+		// the limiter goes last so nothing can push the audio past it
+		if limit != 0 {
+			audioFilter.WriteString(makeLimiterFilter(limit))
+		}
 	} else {
 		log.Print("no audio, ignoring all audio filters")
 	}
@@ -524,16 +1026,23 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 	// corruption calculations based on width and height
 	if corrupt != 0 {
 		// amount of corruption is based on the bitrate of the video, the amount of corruption, and the size of the video
-		corruptAmount = int(float64(outputHeight*outputWidth) / float64(bitrate) * 100000.0 / float64(corrupt*3))
+		corruptAmount = int(float64(finalHeight*finalWidth) / float64(bitrate) * 100000.0 / float64(corrupt*3))
 		corruptFilter = "noise=" + strconv.Itoa(corruptAmount)
 
 		if debug {
 			log.Print("corrupt amount is", corruptAmount)
-			log.Print("(", outputHeight, " * ", outputWidth, ")", " / 2073600 * 1000000", " / ", "(", corrupt, "* 10)")
+			log.Print("(", finalHeight, " * ", finalWidth, ")", " / 2073600 * 1000000", " / ", "(", corrupt, "* 10)")
 			log.Print("corrupt filter is -bsf ", corruptFilter)
 		}
 	}
 
+	// reversing makes a new file from the trimmed part of the input, which is munched instead of the input
+	ffmpegInput := input
+	if reverse || boomerang != 0 {
+		ffmpegInput = reverseInput(input, inputData, renderVideo, hasAudio)
+		defer os.Remove(ffmpegInput)
+	}
+
 	// staring ffmpeg args
 	args := []string{
 		"-y", // forces overwrite of existing file, if one does exist
@@ -543,7 +1052,7 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		"-stats_period", strconv.FormatFloat(updateSpeed, 'f', -1, 64),
 	}
 
-	if start != 0 { // if start is specified
+	if start != 0 && ffmpegInput == input { // if start is specified (and the input wasn't already trimmed when reversing it)
 		args = append(args, "-ss", strconv.FormatFloat(start, 'f', -1, 64)) // -ss is the start time
 	}
 
@@ -551,7 +1060,7 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		outDuration = end - start
 	}
 
-	if outDuration != -1 { // if the duration is specified
+	if outDuration != -1 && ffmpegInput == input { // if the duration is specified
 		args = append(args, "-t", strconv.FormatFloat(outDuration, 'f', -1, 64)) // -t sets the duration
 	}
 
@@ -573,19 +1082,62 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 
 	// add the input to the ffmpeg args
 	args = append(args,
-		"-i", input,
+		"-i", ffmpegInput,
 	)
 
 	// if replaceAudio is specified, add the second input to the ffmpeg args to replace the audio of the output
 	if replaceAudio != "" {
 		args = append(args, "-i", replaceAudio)
-		args = append(args, "-map", "0:v:0")
-		args = append(args, "-map", "1:a:0")
 		if debug {
 			log.Print("replacing audio")
 		}
 	}
 
+	// add the audio to mix in, looping it forever if it should loop since the mix is cut off when the clip ends anyway
+	// if the clip's length is unknown and there's no audio for the mix to end with, there's nothing to cut it off, so it isn't looped
+	if mixAudio != "" {
+		if audioLoop && (hasAudio || realOutputDuration > 0) {
+			args = append(args, "-stream_loop", "-1")
+		} else if audioLoop {
+			fmt.Println(strFmt.warning + "Warning: unable to find the length of the input, so --mix-audio won't be looped" + strFmt.reset)
+		}
+		args = append(args, "-i", mixAudio)
+	}
+
+	// add the overlays and the palette as inputs after the input (and replacement and mixed in audio)
+	if renderVideo {
+		for _, overlay := range imageOverlays {
+			args = append(args, overlayInputArgs(overlay.Path)...)
+		}
+		if palette != "" {
+			args = append(args, "-i", palettePath())
+		}
+	}
+
+	// put the video and audio filters together, labelling their inputs and outputs so they're always mapped to the right streams
+	var filterChains []string
+	if renderVideo {
+		filterChains = append(filterChains, "[0:v]"+filter.String()+"[vout]")
+		args = append(args, "-map", "[vout]")
+	}
+	if renderAudio {
+		if audioFilter.Len() != 0 {
+			// a chain can't start with a label, so anything that starts by splitting the audio gets a filter that does nothing in front of it
+			audioChain := strings.TrimPrefix(audioFilter.String(), ",")
+			if strings.HasPrefix(audioChain, "[") {
+				audioChain = "anull" + audioChain
+			}
+			// without an audio input, the chain starts with a source that makes its own audio
+			if audioInput != "" {
+				audioChain = "[" + audioInput + "]" + audioChain
+			}
+			filterChains = append(filterChains, audioChain+"[aout]")
+			args = append(args, "-map", "[aout]")
+		} else {
+			args = append(args, "-map", audioInput)
+		}
+	}
+
 	// more always-used args
 	if renderVideo {
 		args = append(args,
@@ -606,8 +1158,8 @@ func videoMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 	}
 
 	// if any filters are being used, add them
-	if len(filter.String()) != 0 {
-		args = append(args, "-filter_complex", filter.String())
+	if len(filterChains) != 0 {
+		args = append(args, "-filter_complex", strings.Join(filterChains, ";"))
 	}
 
 	// if corruption is specified, add the corrupt filter to the ffmpeg args
@@ -792,15 +1344,30 @@ func imageMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		}
 	}
 
-	if vignette != 0 {
+	if flip != "" || mirror != "" || rotate != 0 || shake != 0 {
+		filter.WriteString(makeTransformFilter(outputWidth, outputHeight))
+	}
+
+	// the crt effect does its own vignette after curving the screen
+	if vignette != 0 && crt == 0 {
 		filter.WriteString(",vignette=PI/(5/(" + strconv.FormatFloat(vignette, 'f', -1, 64) + "/2))")
 		if debug {
 			log.Print("vignette amount is ", vignette, " or PI/(5/("+strconv.FormatFloat(vignette, 'f', -1, 64)+"/2))")
 		}
 	}
 
-	if text != "" {
-		filter.WriteString(makeTextFilter(outputWidth, text, textFont, fontSize, textColor, textposx, textposy))
+	if len(imageOverlays) != 0 {
+		filter.WriteString(makeOverlayFilter(outputWidth, imageOverlays, 1))
+	}
+
+	if len(textOverlays) != 0 {
+		filter.WriteString(makeTextFilter(outputWidth, outputHeight, textOverlays, input))
+	}
+
+	var caption captionLayout
+	if topText != "" || bottomText != "" {
+		caption = layoutCaption(outputWidth, outputHeight, topText, bottomText, captionFont, captionStyle)
+		filter.WriteString(makeCaptionFilter(outputHeight, caption, captionStyle))
 	}
 
 	if fry != 0 {
@@ -810,6 +1377,31 @@ func imageMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		}
 	}
 
+	if film != 0 {
+		filter.WriteString(makeFilmFilter(film, seed))
+	}
+
+	if rgbSplit != 0 {
+		filter.WriteString(makeRGBSplitFilter(rgbSplit, rgbSplitAngle, rgbSplitJitter, seed))
+	}
+
+	if vhs != 0 {
+		filter.WriteString(makeVHSFilter(outputWidth, outputHeight, vhs, input))
+	}
+
+	if palette != "" {
+		filter.WriteString(makePaletteFilter(1 + len(imageOverlays)))
+	}
+
+	if crt != 0 {
+		filter.WriteString(makeCRTFilter(crt))
+	}
+
+	if upscaleTo != "" {
+		frameWidth, frameHeight := filteredResolution(outputWidth, outputHeight, caption)
+		filter.WriteString(makeUpscaleFilter(upscaleResolution(inputData.Width, inputData.Height, outputWidth, outputHeight, frameWidth, frameHeight)))
+	}
+
 	// staring ffmpeg args
 	args := []string{
 		"-y", // forces overwrite of existing file, if one does exist
@@ -818,14 +1410,25 @@ func imageMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 		"-progress", "-",
 		"-stats_period", strconv.FormatFloat(updateSpeed, 'f', -1, 64),
 		"-i", input,
+	}
+
+	// add the overlays and the palette as inputs after the input
+	for _, overlay := range imageOverlays {
+		args = append(args, overlayInputArgs(overlay.Path)...)
+	}
+	if palette != "" {
+		args = append(args, "-i", palettePath())
+	}
+
+	args = append(args,
 		"-c:v", "mjpeg",
 		"-q:v", "31",
 		"-frames:v", "1",
-	}
+	)
 
 	// if any filters are being used, add them
 	if len(filter.String()) != 0 {
-		args = append(args, "-filter_complex", filter.String())
+		args = append(args, "-filter_complex", "[0:v]"+filter.String())
 	}
 
 	args = append(args, output) // add the output file to the ffmpeg args
@@ -1045,6 +1648,19 @@ func imageMunch(input string, inputData ffprobe.MediaData, inNum int, totalNum i
 	}
 }
 
+// outputDuration finds how long the output will be, which is the part of the input between --start and --end (or
+// --duration), sped up or slowed down by --speed
+func outputDuration(inputDuration float64) float64 {
+	trimmedDuration := inputDuration - start
+	if end != -1 && end-start < trimmedDuration {
+		trimmedDuration = end - start
+	}
+	if outDuration != -1 && outDuration < trimmedDuration {
+		trimmedDuration = outDuration
+	}
+	return trimmedDuration / speed
+}
+
 func getETA(startingTime time.Time, current float64, total float64) float64 {
 	return time.Since(startingTime).Seconds() * (total - current) / current
 }
@@ -1074,42 +1690,672 @@ func newResolution(inWidth int, inHeight int) (int, int) {
 	return outWidth, outHeight
 }
 
-func makeTextFilter(
-	outWidth int,
-	inText string,
-	font string,
-	size float64,
-	color string,
-	xpos int,
-	ypos int,
-) string {
-	fontPath, err := findfont.Find(font + ".ttf")
+// filteredResolution finds the resolution the frame is at after the filters, since some of them make it bigger than the
+// output resolution
+func filteredResolution(outWidth int, outHeight int, caption captionLayout) (int, int) {
+	outHeight += caption.TopBar + caption.BottomBar
+	if crt != 0 {
+		outWidth *= crtPixelSize
+		outHeight *= crtPixelSize
+	}
+	return outWidth, outHeight
+}
+
+// upscaleResolution finds the resolution the output is scaled back up to with --upscale-to, which is the input
+// resolution (still stretched) for source, or the given size
+// the scale is worked out for the video, then the whole frame the filters left is scaled by it, so caption bars are
+// added on top of the size instead of squashing the video into it
+// if the output isn't being scaled back up, it stays at the resolution the filters left it at
+func upscaleResolution(inWidth int, inHeight int, outWidth int, outHeight int, frameWidth int, frameHeight int) (int, int) {
+	if upscaleTo == "" {
+		return frameWidth, frameHeight
+	}
+
+	var scaleX, scaleY float64
+	if upscaleTo == "source" {
+		// the stretch is kept by scaling the output by the same amount in both directions
+		scale := math.Max(float64(inWidth)/float64(outWidth), float64(inHeight)/float64(outHeight))
+		scaleX, scaleY = scale, scale
+	} else {
+		width, height, err := parseSize(upscaleTo)
+		if err != nil {
+			log.Fatal("Upscale size must be source or WxH: ", err)
+		}
+		scaleX = float64(width/2*2) / float64(outWidth)
+		scaleY = float64(height/2*2) / float64(outHeight)
+	}
+
+	// nothing adds to the sides of the frame, so its width shows how much bigger the filters made the video
+	filterScale := float64(frameWidth) / float64(outWidth)
+	return int(math.Round(float64(frameWidth)*scaleX/filterScale/2)) * 2, int(math.Round(float64(frameHeight)*scaleY/filterScale/2)) * 2
+}
+
+// parseSize parses a size like 1280x720
+func parseSize(size string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("expected WxH, got " + size)
+	}
+	width, err := strconv.Atoi(parts[0])
 	if err != nil {
-		panic(err)
+		return 0, 0, err
 	}
+	height, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if width < 2 || height < 2 {
+		return 0, 0, errors.New("width and height must be at least 2")
+	}
+	return width, height, nil
+}
+
+// makeUpscaleFilter scales the output up to width by height without smoothing, so each pixel becomes a block
+func makeUpscaleFilter(width int, height int) string {
+	upscaleFilter := ",scale=" + strconv.Itoa(width) + ":" + strconv.Itoa(height) + ":flags=neighbor,setsar=1:1"
+	if debug {
+		log.Print("upscale filter is ", upscaleFilter)
+	}
+	return upscaleFilter
+}
+
+// textOverlay is a single piece of text drawn on the output
+type textOverlay struct {
+	Text  string  `json:"text"`
+	Font  string  `json:"font"`
+	Color string  `json:"color"`
+	Size  float64 `json:"size"`
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+
+	LineSpacing float64 `json:"line_spacing"`
+	Box         bool    `json:"box"`
+	BoxColor    string  `json:"box_color"`
+	Shadow      float64 `json:"shadow"`
+	BorderColor string  `json:"border_color"`
+	Align       string  `json:"align"`
+	Angle       float64 `json:"angle"`
+}
+
+// defaultTextOverlay returns a text overlay with the same defaults as the text flags
+func defaultTextOverlay() textOverlay {
+	return textOverlay{
+		Font:  "arial",
+		Color: "white",
+		Size:  12,
+		X:     50,
+		Y:     90,
+		Start: 0,
+		End:   -1,
+
+		BoxColor:    "black@0.5",
+		BorderColor: "black",
+		Align:       "left",
+	}
+}
+
+// textFlagOverlays makes a text overlay for every --text, matching it up with the other text flags by position
+func textFlagOverlays() []textOverlay {
+	overlays := []textOverlay{}
+	defaults := defaultTextOverlay()
+	for i, t := range texts {
+		if t == "" {
+			continue
+		}
+		// allow multiline text to be typed as \n on the command line
+		if i < len(texts)-len(textFiles) {
+			t = strings.ReplaceAll(t, `\n`, "\n")
+		}
+		overlays = append(overlays, textOverlay{
+			Text:  t,
+			Font:  nth(textFonts, i, defaults.Font),
+			Color: nth(textColors, i, defaults.Color),
+			Size:  nth(fontSizes, i, defaults.Size),
+			X:     nth(textposx, i, defaults.X),
+			Y:     nth(textposy, i, defaults.Y),
+			Start: nth(textStarts, i, defaults.Start),
+			End:   nth(textEnds, i, defaults.End),
+
+			LineSpacing: nth(lineSpacings, i, defaults.LineSpacing),
+			Box:         nth(textBoxes, i, defaults.Box),
+			BoxColor:    nth(textBoxColors, i, defaults.BoxColor),
+			Shadow:      nth(textShadows, i, defaults.Shadow),
+			BorderColor: nth(textBorderColors, i, defaults.BorderColor),
+			Align:       nth(textAligns, i, defaults.Align),
+			Angle:       nth(textAngles, i, defaults.Angle),
+		})
+	}
+	return overlays
+}
+
+// readTextRecipe reads a JSON list of text overlays, where any missing field uses the default for its flag
+func readTextRecipe(path string) ([]textOverlay, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawOverlays []json.RawMessage
+	if err := json.Unmarshal(data, &rawOverlays); err != nil {
+		return nil, err
+	}
+
+	overlays := []textOverlay{}
+	for _, raw := range rawOverlays {
+		overlay := defaultTextOverlay()
+		if err := json.Unmarshal(raw, &overlay); err != nil {
+			return nil, err
+		}
+		if overlay.Text != "" {
+			overlays = append(overlays, overlay)
+		}
+	}
+	return overlays, nil
+}
+
+// nth returns the ith value of a repeatable flag, the last value if there aren't enough, or fallback if there are none
+func nth[T any](values []T, i int, fallback T) T {
+	if len(values) == 0 {
+		return fallback
+	}
+	if i >= len(values) {
+		return values[len(values)-1]
+	}
+	return values[i]
+}
+
+// newLabel makes a filtergraph link label that won't clash with any other label
+func newLabel(name string) string {
+	labelCount++
+	return "[" + name + strconv.Itoa(labelCount) + "]"
+}
+
+// newTempFont makes a path in temp for a copy of a font that won't clash with any other font
+func newTempFont() string {
+	tempFontCount++
+	return "temp/font" + strconv.Itoa(tempFontCount) + ".ttf"
+}
+
+// makeTextFilter makes a chain of drawtext filters, one for each text overlay
+// rotated text is drawn on a transparent layer the size of the frame, which is rotated and overlaid back on top
+func makeTextFilter(outWidth int, outHeight int, overlays []textOverlay, input string) string {
+	var filter strings.Builder
+
+	for _, overlay := range overlays {
+		tempFont := newTempFont()
+		fontPath := copyFont(overlay.Font, overlay.Text, tempFont)
+
+		size := overlay.Size * float64(outWidth/100)
+		position := ":x=(w-(tw))*(" + strconv.Itoa(overlay.X) + "/100):y=(h-(th))*(" + strconv.Itoa(overlay.Y) + "/100)"
+		if overlay.Angle != 0 {
+			position = ":x=(w-tw)/2:y=(h-th)/2"
+		}
+
+		drawtext := "drawtext=fontfile='" + tempFont + "':text=" + escapeFilterValue(expandTextTokens(overlay.Text, input)) + ":fontcolor=" + overlay.Color + ":bordercolor=" + overlay.BorderColor + ":borderw=(" + strconv.FormatFloat(size, 'f', -1, 64) + "/12):fontsize=" + strconv.FormatFloat(size, 'f', -1, 64) + position
+
+		// only show the text between its start and end times
+		if overlay.End != -1 {
+			drawtext += ":enable='between(t," + strconv.FormatFloat(overlay.Start, 'f', -1, 64) + "," + strconv.FormatFloat(overlay.End, 'f', -1, 64) + ")'"
+		} else if overlay.Start != 0 {
+			drawtext += ":enable='gte(t," + strconv.FormatFloat(overlay.Start, 'f', -1, 64) + ")'"
+		}
+
+		if overlay.LineSpacing != 0 {
+			drawtext += ":line_spacing=" + strconv.FormatFloat(overlay.LineSpacing*float64(outWidth/100), 'f', -1, 64)
+		}
+
+		if overlay.Box {
+			drawtext += ":box=1:boxcolor=" + overlay.BoxColor + ":boxborderw=" + strconv.Itoa(int(math.Max(1, size/6)))
+		}
+
+		if overlay.Shadow != 0 {
+			shadow := strconv.FormatFloat(overlay.Shadow*float64(outWidth/100), 'f', -1, 64)
+			drawtext += ":shadowcolor=black@0.7:shadowx=" + shadow + ":shadowy=" + shadow
+		}
+
+		// left is drawtext's default, so text_align is only used when it's needed since older versions of ffmpeg don't have it
+		switch overlay.Align {
+		case "center":
+			drawtext += ":text_align=C"
+		case "right":
+			drawtext += ":text_align=R"
+		}
+
+		if overlay.Angle == 0 {
+			filter.WriteString("," + drawtext)
+		} else {
+			// estimate the size of the text so the middle of the rotated text ends up where the unrotated text would be
+			lines := strings.Split(overlay.Text, "\n")
+			longestLine := 0
+			for _, line := range lines {
+				longestLine = int(math.Max(float64(longestLine), float64(len([]rune(line)))))
+			}
+			textWidth := float64(longestLine) * size * 0.55
+			textHeight := float64(len(lines))*size*1.2 + float64(len(lines)-1)*overlay.LineSpacing*float64(outWidth/100)
+			xOffset := textWidth/2 + (float64(outWidth)-textWidth)*float64(overlay.X)/100 - float64(outWidth)/2
+			yOffset := textHeight/2 + (float64(outHeight)-textHeight)*float64(overlay.Y)/100 - float64(outHeight)/2
+
+			// the layer runs at the output frame rate so {frame} and the timing tokens match the frames it's put on
+			layerSource := "color=c=black@0:s=" + strconv.Itoa(outWidth) + "x" + strconv.Itoa(outHeight)
+			if outFPS > 0 {
+				layerSource += ":r=" + strconv.Itoa(outFPS)
+			}
+
+			base := newLabel("textbase")
+			layer := newLabel("textlayer")
+			filter.WriteString(base + ";" + layerSource + ",format=rgba," + drawtext + ",rotate=a=" + strconv.FormatFloat(overlay.Angle, 'f', -1, 64) + "*PI/180:c=none" + layer)
+			filter.WriteString(";" + base + layer + "overlay=x=" + strconv.FormatFloat(xOffset, 'f', 1, 64) + ":y=" + strconv.FormatFloat(yOffset, 'f', 1, 64) + ":shortest=1")
+		}
+
+		if debug {
+			log.Println("text is ", overlay.Text)
+			log.Println("fontpath: ", fontPath)
+		}
+	}
+
+	if debug {
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// makeSubtitleFilter makes the filters to burn in subtitles from --subtitles, or from the input's own subtitle stream
+// the subtitles are drawn after scaling so they're as low quality as everything else, which means the timestamps have to be
+// put back to where they were in the input (before --start and --speed changed them) while the subtitles are drawn
+func makeSubtitleFilter(input string) string {
+	subtitleFile := subtitles
+	if subtitleFile == "" {
+		subtitleFile = input
+	}
+
+	startString := strconv.FormatFloat(start, 'f', -1, 64)
+	speedString := strconv.FormatFloat(speed, 'f', -1, 64)
+
+	filter := ",setpts=PTS*" + speedString + "+" + startString + "/TB,subtitles=filename=" + escapeFilterValue(subtitleFile)
+	if subtitleStream != -1 {
+		filter += ":si=" + strconv.Itoa(subtitleStream)
+	}
+	filter += ",setpts=(PTS-" + startString + "/TB)/" + speedString
+
+	if debug {
+		log.Println("subtitles are from ", subtitleFile)
+		log.Println(filter)
+	}
+
+	return filter
+}
+
+// imageOverlay is an image or GIF drawn on top of the output
+type imageOverlay struct {
+	Path    string
+	X, Y    int
+	Scale   float64
+	Opacity float64
+	Start   float64
+	End     float64
+}
+
+// imageFlagOverlays makes an image overlay for every --overlay, matching it up with the other overlay flags by position
+func imageFlagOverlays() []imageOverlay {
+	overlays := []imageOverlay{}
+	for i, path := range overlayFiles {
+		if path == "" {
+			continue
+		}
+		overlays = append(overlays, imageOverlay{
+			Path:    path,
+			X:       nth(overlayposx, i, 50),
+			Y:       nth(overlayposy, i, 50),
+			Scale:   nth(overlayScales, i, 0.25),
+			Opacity: nth(overlayOpacities, i, 1.0),
+			Start:   nth(overlayStarts, i, 0.0),
+			End:     nth(overlayEnds, i, -1.0),
+		})
+	}
+	return overlays
+}
+
+// overlayInputArgs returns the ffmpeg args to add an overlay as an input that lasts forever, since the overlay filter stops with the main input anyway
+func overlayInputArgs(path string) []string {
+	if strings.ToLower(filepath.Ext(path)) == ".gif" {
+		// loop animated gifs forever, since a gif's own loop count can make it end and take the output with it
+		return []string{"-stream_loop", "-1", "-i", path}
+	}
+	return []string{"-loop", "1", "-i", path}
+}
+
+// makeOverlayFilter makes the filters to scale each overlay and put it on top of the output
+// the overlays have to be the inputs starting at firstInput, in the same order
+func makeOverlayFilter(outWidth int, overlays []imageOverlay, firstInput int) string {
+	var filter strings.Builder
+
+	for i, overlay := range overlays {
+		base := newLabel("overlaybase")
+		layer := newLabel("overlaylayer")
+
+		// make the overlay the right size and transparency, keeping the width even so it scales cleanly
+		overlayWidth := int(math.Max(math.Round(float64(outWidth)*overlay.Scale/2)*2, 2))
+		filter.WriteString(base + ";[" + strconv.Itoa(firstInput+i) + ":v]scale=" + strconv.Itoa(overlayWidth) + ":-1,format=rgba")
+		if overlay.Opacity < 1 {
+			filter.WriteString(",colorchannelmixer=aa=" + strconv.FormatFloat(math.Max(overlay.Opacity, 0), 'f', -1, 64))
+		}
+		filter.WriteString(layer + ";" + base + layer + "overlay=x=(W-w)*(" + strconv.Itoa(overlay.X) + "/100):y=(H-h)*(" + strconv.Itoa(overlay.Y) + "/100):shortest=1")
+
+		// only show the overlay between its start and end times
+		if overlay.End != -1 {
+			filter.WriteString(":enable='between(t," + strconv.FormatFloat(overlay.Start, 'f', -1, 64) + "," + strconv.FormatFloat(overlay.End, 'f', -1, 64) + ")'")
+		} else if overlay.Start != 0 {
+			filter.WriteString(":enable='gte(t," + strconv.FormatFloat(overlay.Start, 'f', -1, 64) + ")'")
+		}
+
+		if debug {
+			log.Println("overlay is ", overlay.Path, " at width ", overlayWidth)
+		}
+	}
+
+	if debug {
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
+
+// copyFont finds the given font (by path or name) or the first fallback font that can draw all of inText, and copies it to tempPath so ffmpeg can use it
+// if the font is empty or can't be found, the built-in font is used instead; the path of the font that was used is returned
+func copyFont(font string, inText string, tempPath string) string {
 	if err := os.MkdirAll("temp", os.ModePerm); err != nil {
 		log.Fatal(err)
 	}
+
+	var fontPath string
+	var err error
+	if font != "" {
+		fontPath, err = fonts.Find(font)
+		if err != nil {
+			fmt.Println(strFmt.warning+"Warning: unable to find the font", strFmt.warningHL+font+strFmt.warning+", using the built-in font instead"+strFmt.reset)
+		}
+	}
+	if font == "" || err != nil {
+		fontPath = "temp/builtin.ttf"
+		if err := ioutil.WriteFile(fontPath, fonts.Builtin, 0644); err != nil {
+			log.Fatal(strFmt.error + "Fatal Error: unable to create " + fontPath + strFmt.reset)
+		}
+	}
+
+	// if the font is missing any characters, try the fallback fonts
+	if covered, _ := fonts.Covers(fontPath, inText); !covered {
+		for _, fallback := range fallbackFonts {
+			fallbackPath, err := fonts.Find(fallback)
+			if err != nil {
+				continue
+			}
+			if fallbackCovered, _ := fonts.Covers(fallbackPath, inText); fallbackCovered {
+				if debug {
+					log.Println("font ", fontPath, " is missing characters, using fallback ", fallbackPath)
+				}
+				fontPath = fallbackPath
+				covered = true
+				break
+			}
+		}
+		// each piece of text is drawn in one font, so text that needs more than one (like letters and emoji when the
+		// emoji font doesn't have letters) can't be drawn fully
+		if !covered {
+			fmt.Println(strFmt.warning+"Warning: no font has every character in", strFmt.warningHL+inText+strFmt.warning+", so some of them won't show up"+strFmt.reset)
+		}
+	}
+
 	input, err := ioutil.ReadFile(fontPath)
 	if err != nil {
-		log.Print(err)
+		log.Fatal(strFmt.error + "Fatal Error: unable to read the font " + fontPath + ": " + err.Error() + strFmt.reset)
 	}
-	err = ioutil.WriteFile("temp/font.ttf", input, 0644)
+	err = ioutil.WriteFile(tempPath, input, 0644)
 	if err != nil {
-		log.Fatal(strFmt.error + "Fatal Error: unable to create temp/font.ttf" + strFmt.reset)
+		log.Fatal(strFmt.error + "Fatal Error: unable to create " + tempPath + strFmt.reset)
+	}
+	return fontPath
+}
+
+// listFonts prints every font on the system that can be used with the text flags
+func listFonts() {
+	allFonts := fonts.List()
+	for _, font := range allFonts {
+		name := filepath.Base(font.Path)
+		if len(font.Names) != 0 {
+			name = strings.Join(font.Names, ", ")
+		}
+		fmt.Println(strFmt.infoHL+name+strFmt.reset, strFmt.info+font.Path+strFmt.reset)
+	}
+	fmt.Println(strFmt.success+"Found", strconv.Itoa(len(allFonts)), "fonts (the built-in font is used when a font can't be found)"+strFmt.reset)
+}
+
+// escapeDrawtext escapes text so that it is drawn exactly as given by drawtext
+func escapeDrawtext(inText string) string {
+	return escapeFilterValue(escapeExpansion(inText))
+}
+
+// escapeExpansion escapes text so drawtext doesn't expand anything in it
+func escapeExpansion(inText string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`).Replace(inText)
+}
+
+// escapeFilterValue quotes and escapes a value so it can be used as a filter option in a filtergraph
+// there are two levels of escaping: the filter's options (which we quote) and the filtergraph itself
+func escapeFilterValue(value string) string {
+	escaped := "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`, "[", `\[`, "]", `\]`, ",", `\,`, ";", `\;`).Replace(escaped)
+}
+
+// escapeExpansionArg escapes the last argument of a drawtext expansion, since colons separate the arguments
+func escapeExpansionArg(arg string) string {
+	return strings.NewReplacer(`\`, `\\`, ":", `\:`, "}", `\}`).Replace(arg)
+}
+
+var textTokenRegex = regexp.MustCompile(`\{(pts|frame|filename|date|localtime)(?::([^}]*))?\}`)
+
+// expandTextTokens turns the tokens in text into drawtext expansions (or fills them in) and escapes everything else
+// {pts} is the timestamp, {frame} is the frame number, {filename} is the input's name, {date:layout} is today's date as a go time layout, and {localtime:format} is the current time as a strftime format
+func expandTextTokens(inText string, input string) string {
+	var expanded strings.Builder
+	last := 0
+	for _, match := range textTokenRegex.FindAllStringSubmatchIndex(inText, -1) {
+		expanded.WriteString(escapeExpansion(inText[last:match[0]]))
+		last = match[1]
+
+		token := inText[match[2]:match[3]]
+		arg := ""
+		if match[4] != -1 {
+			arg = inText[match[4]:match[5]]
+		}
+
+		switch token {
+		case "pts":
+			// the argument is passed to drawtext, such as {pts:hms} or {pts:gmtime:0:%H:%M:%S}, where the last part is a
+			// strftime format that can have colons in it
+			if arg != "" {
+				parts := strings.SplitN(arg, ":", 3)
+				if len(parts) == 3 {
+					parts[2] = escapeExpansionArg(parts[2])
+				}
+				expanded.WriteString("%{pts:" + strings.Join(parts, ":") + "}")
+			} else {
+				expanded.WriteString("%{pts}")
+			}
+		case "frame":
+			expanded.WriteString("%{n}")
+		case "filename":
+			expanded.WriteString(escapeExpansion(filepath.Base(input)))
+		case "date":
+			if arg == "" {
+				arg = "2006-01-02"
+			}
+			expanded.WriteString(escapeExpansion(time.Now().Format(arg)))
+		case "localtime":
+			if arg != "" {
+				expanded.WriteString("%{localtime:" + escapeExpansionArg(arg) + "}")
+			} else {
+				expanded.WriteString("%{localtime}")
+			}
+		}
+	}
+	expanded.WriteString(escapeExpansion(inText[last:]))
+
+	return expanded.String()
+}
+
+// roughly how wide a character of caption text is compared to the font size, for fonts that can't be measured
+const captionCharWidth = 0.7
+
+// captionLayout is how a top/bottom meme caption fits on the frame: the font size and wrapped lines of each caption, and
+// the height of the bar each one adds with the bar style
+type captionLayout struct {
+	TopSize, BottomSize   int
+	TopLines, BottomLines []string
+	TopBar, BottomBar     int
+}
+
+// layoutCaption copies the caption font and fits the captions to the frame, measuring them with the font that's used
+func layoutCaption(outWidth int, outHeight int, top string, bottom string, font string, style string) captionLayout {
+	fontPath := copyFont(font, top+bottom, "temp/caption.ttf")
+
+	// the classic impact style is always upper-cased
+	if style == "impact" {
+		top = strings.ToUpper(top)
+		bottom = strings.ToUpper(bottom)
+	}
+
+	measure := func(text string) float64 {
+		return float64(len([]rune(text))) * captionCharWidth
 	}
+	if metrics, err := fonts.LoadMetrics(fontPath); err == nil {
+		measure = metrics.Width
+	} else if debug {
+		log.Println("unable to measure the caption font, guessing its width: ", err)
+	}
+
+	var caption captionLayout
+	caption.TopSize, caption.TopLines = fitCaption(top, outWidth, outHeight, measure)
+	caption.BottomSize, caption.BottomLines = fitCaption(bottom, outWidth, outHeight, measure)
 
-	filter := ",drawtext=fontfile='temp/font.ttf':text='" + inText + "':fontcolor=" + color + ":borderw=(" + strconv.FormatFloat(size*float64(outWidth/100), 'f', -1, 64) + "/12):fontsize=" + strconv.FormatFloat(size*float64(outWidth/100), 'f', -1, 64) + ":x=(w-(tw))*(" + strconv.Itoa(xpos) + "/100):y=(h-(th))*(" + strconv.Itoa(ypos) + "/100)"
+	// pad the frame with a white bar for each caption
+	if style == "bar" {
+		caption.TopBar = captionBlockHeight(caption.TopSize, len(caption.TopLines))
+		caption.BottomBar = captionBlockHeight(caption.BottomSize, len(caption.BottomLines))
+	}
 
 	if debug {
-		log.Println("text is ", inText)
-		log.Println("fontpath: ", fontPath)
-		log.Println(filter)
+		log.Println("caption style is ", style)
+		log.Println("caption fontpath: ", fontPath)
+		log.Println("top caption: ", caption.TopLines, " at size ", caption.TopSize)
+		log.Println("bottom caption: ", caption.BottomLines, " at size ", caption.BottomSize)
+	}
+
+	return caption
+}
+
+// makeCaptionFilter makes the filters for a top/bottom meme caption in either the impact or bar style
+func makeCaptionFilter(outHeight int, caption captionLayout, style string) string {
+	var filter strings.Builder
+
+	if style == "bar" {
+		filter.WriteString(",pad=w=iw:h=ih+" + strconv.Itoa(caption.TopBar+caption.BottomBar) + ":x=0:y=" + strconv.Itoa(caption.TopBar) + ":color=white")
+
+		for i, line := range caption.TopLines {
+			y := float64(caption.TopSize)*0.5 + float64(i)*float64(caption.TopSize)*1.1
+			filter.WriteString(makeCaptionLine(line, caption.TopSize, "black", "", y))
+		}
+		for i, line := range caption.BottomLines {
+			y := float64(caption.TopBar+outHeight) + float64(caption.BottomSize)*0.5 + float64(i)*float64(caption.BottomSize)*1.1
+			filter.WriteString(makeCaptionLine(line, caption.BottomSize, "black", "", y))
+		}
+	} else {
+		margin := float64(outHeight) * 0.03
+		for i, line := range caption.TopLines {
+			y := margin + float64(i)*float64(caption.TopSize)*1.1
+			filter.WriteString(makeCaptionLine(line, caption.TopSize, "white", "black", y))
+		}
+		for i, line := range caption.BottomLines {
+			y := float64(outHeight) - margin - float64(len(caption.BottomLines)-i)*float64(caption.BottomSize)*1.1
+			filter.WriteString(makeCaptionLine(line, caption.BottomSize, "white", "black", y))
+		}
+	}
+
+	if debug {
+		log.Println(filter.String())
 	}
 
+	return filter.String()
+}
+
+// makeCaptionLine makes a drawtext filter for one horizontally centered line of a caption
+func makeCaptionLine(line string, size int, color string, borderColor string, y float64) string {
+	filter := ",drawtext=fontfile='temp/caption.ttf':text=" + escapeDrawtext(line) + ":fontcolor=" + color + ":fontsize=" + strconv.Itoa(size) + ":x=(w-tw)/2:y=" + strconv.FormatFloat(y, 'f', 1, 64)
+	if borderColor != "" {
+		filter += ":bordercolor=" + borderColor + ":borderw=" + strconv.Itoa(int(math.Max(1, float64(size)/16)))
+	}
 	return filter
 }
 
+// captionBlockHeight returns the even height of a bar that fits the given number of caption lines
+func captionBlockHeight(size int, lines int) int {
+	if lines == 0 {
+		return 0
+	}
+	return int(math.Round((float64(lines)*1.1+0.9)*float64(size)/2)) * 2
+}
+
+// fitCaption finds the largest font size where the caption fits in the width of the frame and doesn't cover too much of it, and returns it with the wrapped lines
+// measure gives the width of some text in ems
+func fitCaption(caption string, outWidth int, outHeight int, measure func(string) float64) (int, []string) {
+	if caption == "" {
+		return 0, nil
+	}
+
+	maxSize := int(math.Max(float64(outWidth)/7, 1))
+	minSize := int(math.Max(float64(outWidth)/30, 1))
+
+	var lines []string
+	for size := maxSize; size >= minSize; size-- {
+		maxWidth := float64(outWidth) * 0.94 / float64(size)
+		lines = wrapText(caption, maxWidth, measure)
+
+		fits := float64(len(lines))*float64(size)*1.1 <= float64(outHeight)/4
+		for _, line := range lines {
+			if measure(line) > maxWidth {
+				fits = false
+			}
+		}
+		if fits {
+			return size, lines
+		}
+	}
+
+	return minSize, lines
+}
+
+// wrapText splits text into lines that measure at most maxWidth, only breaking between words (words wider than maxWidth get a line to themselves)
+func wrapText(inText string, maxWidth float64, measure func(string) float64) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(inText) {
+		if line == "" {
+			line = word
+		} else if measure(line+" "+word) <= maxWidth {
+			line += " " + word
+		} else {
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
 func Stream(input string, stream string) bool {
 	args := []string{
 		"-i", input,
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"qm-go/fonts"
+)
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", `\'plain\'`},
+		{"it's", `\'it\'\\\'\'s\'`},
+		{"a:b", `\'a:b\'`},
+		{"a,b", `\'a\,b\'`},
+		{"[a]", `\'\[a\]\'`},
+		{"a;b", `\'a\;b\'`},
+		{`a\b`, `\'a\\b\'`},
+		{"C:/fonts/it's.ttf", `\'C:/fonts/it\'\\\'\'s.ttf\'`},
+	}
+
+	for _, test := range tests {
+		if got := escapeFilterValue(test.value); got != test.want {
+			t.Errorf("escapeFilterValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestEscapeDrawtext(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"don't", `\'don\'\\\'\'t\'`},
+		{"12:30", `\'12:30\'`},
+		{"yes, no", `\'yes\, no\'`},
+		{"[REC]", `\'\[REC\]\'`},
+		{"a;b", `\'a\;b\'`},
+		{"100%", `\'100\\%\'`},
+		{"%{n}", `\'\\%{n}\'`},
+		{`a\b`, `\'a\\\\b\'`},
+		{"top\nbottom", "\\'top\nbottom\\'"},
+	}
+
+	for _, test := range tests {
+		if got := escapeDrawtext(test.text); got != test.want {
+			t.Errorf("escapeDrawtext(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestExpandTextTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"no tokens", "no tokens"},
+		{"{pts}", "%{pts}"},
+		{"{pts:hms}", "%{pts:hms}"},
+		{"{pts:gmtime:0:%H:%M:%S}", `%{pts:gmtime:0:%H\:%M\:%S}`},
+		{"frame {frame}", "frame %{n}"},
+		{"{filename}", `100\%.mp4`},
+		{"{localtime}", "%{localtime}"},
+		{"{localtime:%H:%M}", `%{localtime:%H\:%M}`},
+		{"REC {localtime:%H:%M:%S} 50%", `REC %{localtime:%H\:%M\:%S} 50\%`},
+		{`C:\{frame}`, `C:\\%{n}`},
+		{"{unknown}", "{unknown}"},
+	}
+
+	for _, test := range tests {
+		if got := expandTextTokens(test.text, "clips/100%.mp4"); got != test.want {
+			t.Errorf("expandTextTokens(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestFitCaption(t *testing.T) {
+	metrics, err := fonts.LoadMetrics("fonts/builtin.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		caption       string
+		width, height int
+	}{
+		{"WHEN YOU SEE IT", 480, 270},
+		{"WHEN YOU SEE IT", 1920, 1080},
+		{"NOBODY: ABSOLUTELY NOBODY: ME AT 3AM", 480, 480},
+		{"WWWWWWWW", 320, 240},
+	}
+
+	for _, test := range tests {
+		size, lines := fitCaption(test.caption, test.width, test.height, metrics.Width)
+		for _, line := range lines {
+			if width := metrics.Width(line) * float64(size); width > float64(test.width) {
+				t.Errorf("fitCaption(%q, %d, %d) made %q %.0f pixels wide at size %d", test.caption, test.width, test.height, line, width, size)
+			}
+		}
+	}
+}
+
+func TestMakeTempoFilter(t *testing.T) {
+	for _, tempo := range []float64{0.001, 0.25, 0.3, 0.5, 1, 2, 3, 100} {
+		filter := makeTempoFilter(tempo)
+
+		// every atempo has to be from 0.5 to 2, and they have to multiply back to the tempo
+		product := 1.0
+		for _, part := range strings.Split(strings.TrimPrefix(filter, ","), ",") {
+			if part == "" {
+				continue
+			}
+			factor, err := strconv.ParseFloat(strings.TrimPrefix(part, "atempo="), 64)
+			if err != nil {
+				t.Fatalf("makeTempoFilter(%v) = %q, which has a bad atempo: %v", tempo, filter, err)
+			}
+			if factor < 0.5 || factor > 2 {
+				t.Errorf("makeTempoFilter(%v) = %q, which has atempo=%v", tempo, filter, factor)
+			}
+			product *= factor
+		}
+		if math.Abs(product-tempo) > tempo*1e-9 {
+			t.Errorf("makeTempoFilter(%v) = %q, which multiplies to %v", tempo, filter, product)
+		}
+	}
+
+	if filter := makeTempoFilter(1); filter != "" {
+		t.Errorf("makeTempoFilter(1) = %q, want no filter", filter)
+	}
+}
+
+func TestOutputDuration(t *testing.T) {
+	defer func(oldStart, oldEnd, oldDuration, oldSpeed float64) {
+		start, end, outDuration, speed = oldStart, oldEnd, oldDuration, oldSpeed
+	}(start, end, outDuration, speed)
+
+	tests := []struct {
+		start, end, duration, speed float64
+		want                        float64
+	}{
+		{0, -1, -1, 1, 60},
+		{10, -1, -1, 1, 50},
+		{10, 30, -1, 1, 20},
+		{10, 100, -1, 1, 50},
+		{10, -1, 5, 1, 5},
+		{10, -1, 100, 1, 50},
+		{10, 30, -1, 2, 10},
+		{0, -1, 5, 0.25, 20},
+		{0, -1, 3, 0.3, 10},
+		{0, -1, -1, 3, 20},
+		{0, -1, -1, 100, 0.6},
+		{0, 1, -1, 0.001, 1000},
+	}
+
+	for _, test := range tests {
+		start, end, outDuration, speed = test.start, test.end, test.duration, test.speed
+		if got := outputDuration(60); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("outputDuration(60) with --start %v --end %v --duration %v --speed %v = %v, want %v", test.start, test.end, test.duration, test.speed, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the built-in palettes for --palette
+var palettes = map[string][]string{
+	"gameboy": {"0f380f", "306230", "8bac0f", "9bbc0f"},
+	"cga":     {"000000", "55ffff", "ff55ff", "ffffff"},
+	"ega":     {"000000", "0000aa", "00aa00", "00aaaa", "aa0000", "aa00aa", "aa5500", "aaaaaa", "555555", "5555ff", "55ff55", "55ffff", "ff5555", "ff55ff", "ffff55", "ffffff"},
+	"1bit":    {"000000", "ffffff"},
+	"websafe": websafeColors(),
+}
+
+// the --dither modes and the paletteuse dither they use
+var ditherModes = map[string]string{
+	"none":            "none",
+	"bayer":           "bayer",
+	"floyd_steinberg": "floyd_steinberg",
+	"sierra":          "sierra2_4a",
+}
+
+// websafeColors returns the 216 web-safe colors
+func websafeColors() []string {
+	steps := []string{"00", "33", "66", "99", "cc", "ff"}
+	var colors []string
+	for _, r := range steps {
+		for _, g := range steps {
+			for _, b := range steps {
+				colors = append(colors, r+g+b)
+			}
+		}
+	}
+	return colors
+}
+
+// parsePalette turns the name of a built-in palette or a comma separated list of hex colors (like #0f380f or #fff) into a list of colors
+func parsePalette(name string) ([]color.RGBA, error) {
+	hexColors, ok := palettes[strings.ToLower(name)]
+	if !ok {
+		hexColors = strings.Split(name, ",")
+	}
+	if len(hexColors) > 256 {
+		return nil, errors.New("palettes can't have more than 256 colors")
+	}
+
+	var colors []color.RGBA
+	for _, hexColor := range hexColors {
+		hexColor = strings.TrimPrefix(strings.TrimSpace(hexColor), "#")
+		if len(hexColor) == 3 {
+			hexColor = string([]byte{hexColor[0], hexColor[0], hexColor[1], hexColor[1], hexColor[2], hexColor[2]})
+		}
+		if len(hexColor) != 6 {
+			return nil, errors.New(name + " is not a built-in palette, an image, or a list of hex colors")
+		}
+		value, err := strconv.ParseUint(hexColor, 16, 32)
+		if err != nil {
+			return nil, errors.New(name + " is not a built-in palette, an image, or a list of hex colors")
+		}
+		colors = append(colors, color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255})
+	}
+	return colors, nil
+}
+
+// palettePath returns the file that is used as the palette input, which is either the image from --palette or a generated palette
+func palettePath() string {
+	if len(paletteColors) == 0 {
+		return palette
+	}
+	return "temp/palette.png"
+}
+
+// writePalette saves the palette colors as a 16x16 image, which is what paletteuse wants
+// if there are less than 256 colors, the last one is repeated
+func writePalette() {
+	if err := os.MkdirAll("temp", os.ModePerm); err != nil {
+		log.Fatal(err)
+	}
+
+	paletteImage := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < 256; i++ {
+		colorIndex := i
+		if colorIndex >= len(paletteColors) {
+			colorIndex = len(paletteColors) - 1
+		}
+		paletteImage.SetRGBA(i%16, i/16, paletteColors[colorIndex])
+	}
+
+	file, err := os.Create(palettePath())
+	if err != nil {
+		log.Fatal(strFmt.error + "Fatal Error: unable to create " + palettePath() + strFmt.reset)
+	}
+	defer file.Close()
+	if err := png.Encode(file, paletteImage); err != nil {
+		log.Fatal(strFmt.error + "Fatal Error: unable to create " + palettePath() + strFmt.reset)
+	}
+}
+
+// makePaletteFilter makes the filters to reduce the colors to the palette, which has to be the input at paletteInput
+func makePaletteFilter(paletteInput int) string {
+	var filter strings.Builder
+
+	base := newLabel("palettebase")
+	if len(paletteColors) != 0 {
+		writePalette()
+		filter.WriteString(base + ";" + base + "[" + strconv.Itoa(paletteInput) + ":v]")
+	} else {
+		// take the palette from the image
+		colors := newLabel("palettecolors")
+		filter.WriteString(base + ";[" + strconv.Itoa(paletteInput) + ":v]palettegen=max_colors=" + strconv.Itoa(paletteColorCount) + ":reserve_transparent=0" + colors + ";" + base + colors)
+	}
+	filter.WriteString("paletteuse=dither=" + ditherModes[dither])
+
+	if debug {
+		log.Print("palette is ", palette, " with ", dither, " dithering")
+		log.Println(filter.String())
+	}
+
+	return filter.String()
+}
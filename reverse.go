@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"qm-go/ffprobe"
+)
+
+// the most memory (in bytes) that reversing one segment of video is allowed to use, since reverse keeps every frame of it
+const reverseSegmentMemory = 256 * 1024 * 1024
+
+// how long each segment is when reversing audio only
+const reverseAudioSegmentLength = 60.0
+
+// reverseInput makes a copy of the trimmed part of the input that's reversed for --reverse, or that plays forward then
+// backward for --boomerang, and returns its path
+// the input is reversed in segments that are each small enough to fit in memory, which are then put together in reverse
+// order, so long inputs can still be reversed
+func reverseInput(input string, inputData ffprobe.MediaData, renderVideo bool, renderAudio bool) string {
+	if err := os.MkdirAll("temp", os.ModePerm); err != nil {
+		log.Fatal(err)
+	}
+
+	// the part of the input between --start and --end (or --duration)
+	from := start
+	to := inputData.Duration
+	if end != -1 && end < to {
+		to = end
+	}
+	if outDuration != -1 && start+outDuration < to {
+		to = start + outDuration
+	}
+
+	segmentLength := reverseSegmentLength(inputData, renderVideo)
+	segmentCount := int(math.Ceil((to - from) / segmentLength))
+
+	fmt.Println(strFmt.working+"Reversing "+strFmt.workingHL+filepath.Base(input), strFmt.working+"in", strFmt.workingHL+strconv.Itoa(segmentCount), strFmt.working+"segment(s)"+strFmt.reset)
+
+	var tempFiles []string
+	var reversed []string
+	for i := segmentCount - 1; i >= 0; i-- {
+		segmentStart := from + float64(i)*segmentLength
+		segment := "temp/reverse" + strconv.Itoa(i) + ".mkv"
+		runReverseFFmpeg(input, segmentStart, math.Min(segmentLength, to-segmentStart), true, renderVideo, renderAudio, segment)
+		tempFiles = append(tempFiles, segment)
+		reversed = append(reversed, segment)
+	}
+
+	// boomerang plays forward then backward (or backward then forward with --reverse) as many times as it's set to
+	parts := reversed
+	if boomerang != 0 {
+		forward := "temp/forward.mkv"
+		runReverseFFmpeg(input, from, to-from, false, renderVideo, renderAudio, forward)
+		tempFiles = append(tempFiles, forward)
+
+		parts = nil
+		for i := 0; i < boomerang; i++ {
+			if reverse {
+				parts = append(parts, reversed...)
+				parts = append(parts, forward)
+			} else {
+				parts = append(parts, forward)
+				parts = append(parts, reversed...)
+			}
+		}
+	}
+
+	// put the parts together with the concat demuxer, which is given paths relative to the list
+	var list strings.Builder
+	for _, part := range parts {
+		list.WriteString("file '" + filepath.Base(part) + "'\n")
+	}
+	listPath := "temp/reverse.txt"
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		log.Fatal(err)
+	}
+	tempFiles = append(tempFiles, listPath)
+
+	reversedInput := "temp/reversed.mkv"
+	args := []string{
+		"-y",
+		"-loglevel", loglevel,
+		"-hide_banner",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", listPath,
+		"-c", "copy",
+		reversedInput,
+	}
+	if debug {
+		log.Print(args)
+	}
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		log.Fatal(strFmt.error+"Fatal Error: unable to put the reversed segments together: ", err, "\n", string(out), strFmt.reset)
+	}
+
+	for _, tempFile := range tempFiles {
+		os.Remove(tempFile)
+	}
+
+	return reversedInput
+}
+
+// reverseSegmentLength finds how long each segment can be while keeping it under reverseSegmentMemory, going by the
+// size of the input's frames (which are 1.5 bytes per pixel in yuv420p)
+func reverseSegmentLength(inputData ffprobe.MediaData, renderVideo bool) float64 {
+	if !renderVideo || inputData.Width == 0 || inputData.Height == 0 || inputData.Framerate == 0 {
+		return reverseAudioSegmentLength
+	}
+	frames := float64(reverseSegmentMemory) / (float64(inputData.Width*inputData.Height) * 1.5)
+	return math.Max(0.5, math.Floor(frames/inputData.Framerate*10)/10)
+}
+
+// runReverseFFmpeg copies length seconds of the input starting at from to output, reversing it if reversed is true
+// the copy is lossless so the output is only munched once
+func runReverseFFmpeg(input string, from float64, length float64, reversed bool, renderVideo bool, renderAudio bool, output string) {
+	args := []string{
+		"-y",
+		"-loglevel", loglevel,
+		"-hide_banner",
+		"-ss", strconv.FormatFloat(from, 'f', -1, 64),
+		"-t", strconv.FormatFloat(length, 'f', -1, 64),
+		"-i", input,
+	}
+
+	if renderVideo {
+		if reversed {
+			args = append(args, "-vf", "reverse")
+		}
+		args = append(args, "-c:v", "libx264", "-preset", "ultrafast", "-qp", "0")
+	} else {
+		args = append(args, "-vn")
+	}
+
+	// replacement audio isn't reversed, so there's no need to keep the input's
+	if renderAudio && replaceAudio == "" {
+		if reversed {
+			args = append(args, "-af", "areverse")
+		}
+		args = append(args, "-c:a", "pcm_s16le")
+	} else {
+		args = append(args, "-an")
+	}
+
+	args = append(args, output)
+
+	if debug {
+		log.Print(args)
+	}
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		log.Fatal(strFmt.error+"Fatal Error: unable to reverse the input: ", err, "\n", string(out), strFmt.reset)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// mirrorModes are the ways --mirror can reflect the frame, each one keeps a part of the frame and reflects it over the rest
+var mirrorModes = map[string]bool{
+	"left":   true,
+	"right":  true,
+	"top":    true,
+	"bottom": true,
+	"quad":   true,
+}
+
+// makeTransformFilter makes the filters for the geometric transforms: mirroring, flipping, rotating, then shaking
+func makeTransformFilter(outWidth int, outHeight int) string {
+	var filter strings.Builder
+
+	if mirror != "" {
+		filter.WriteString(makeMirrorFilter(mirror))
+	}
+
+	switch flip {
+	case "h":
+		filter.WriteString(",hflip")
+	case "v":
+		filter.WriteString(",vflip")
+	case "both":
+		filter.WriteString(",hflip,vflip")
+	}
+
+	if rotate != 0 {
+		filter.WriteString(",rotate=a=" + strconv.FormatFloat(rotate, 'f', -1, 64) + "*PI/180:fillcolor=" + escapeFilterValue(rotateFill))
+	}
+
+	if shake != 0 {
+		filter.WriteString(makeShakeFilter(outWidth, outHeight, shake, seed))
+	}
+
+	if debug {
+		log.Print("transform filter is ", filter.String())
+	}
+
+	return filter.String()
+}
+
+// makeMirrorFilter keeps half (or a quarter, for quad) of the frame and reflects it over the rest, like a kaleidoscope
+func makeMirrorFilter(mode string) string {
+	kept := newLabel("mirrorkept")
+	copied := newLabel("mirrorcopy")
+	reflected := newLabel("mirrorreflected")
+
+	switch mode {
+	case "left":
+		return ",crop=iw/2:ih:0:0,split" + kept + copied + ";" + copied + "hflip" + reflected + ";" + kept + reflected + "hstack"
+	case "right":
+		return ",crop=iw/2:ih:iw/2:0,split" + kept + copied + ";" + copied + "hflip" + reflected + ";" + reflected + kept + "hstack"
+	case "top":
+		return ",crop=iw:ih/2:0:0,split" + kept + copied + ";" + copied + "vflip" + reflected + ";" + kept + reflected + "vstack"
+	case "bottom":
+		return ",crop=iw:ih/2:0:ih/2,split" + kept + copied + ";" + copied + "vflip" + reflected + ";" + reflected + kept + "vstack"
+	case "quad":
+		// the top left quarter is reflected into the other three corners
+		copied2 := newLabel("mirrorcopy")
+		copied3 := newLabel("mirrorcopy")
+		reflected2 := newLabel("mirrorreflected")
+		reflected3 := newLabel("mirrorreflected")
+		top := newLabel("mirrortop")
+		bottom := newLabel("mirrorbottom")
+		return ",crop=iw/2:ih/2:0:0,split=4" + kept + copied + copied2 + copied3 +
+			";" + copied + "hflip" + reflected +
+			";" + copied2 + "vflip" + reflected2 +
+			";" + copied3 + "hflip,vflip" + reflected3 +
+			";" + kept + reflected + "hstack" + top +
+			";" + reflected2 + reflected3 + "hstack" + bottom +
+			";" + top + bottom + "vstack"
+	}
+	return ""
+}
+
+// makeShakeFilter moves the frame up to amount pixels in a random direction every frame, using seed
+// the frame is cropped so the edges never show, then scaled back up to the output size
+func makeShakeFilter(outWidth int, outHeight int, amount int, seed int) string {
+	// the crop can't be bigger than the frame
+	maxAmount := outWidth/2 - 1
+	if outHeight/2-1 < maxAmount {
+		maxAmount = outHeight/2 - 1
+	}
+	if amount > maxAmount {
+		fmt.Println(strFmt.warning+"Warning: shake is too big for the output size, using", strFmt.warningHL+strconv.Itoa(maxAmount)+strFmt.reset)
+		amount = maxAmount
+	}
+	if amount < 1 {
+		return ""
+	}
+
+	a := strconv.Itoa(amount)
+	return ",crop=w=iw-2*" + a + ":h=ih-2*" + a +
+		":x='" + a + "+round(" + a + "*(2*" + seededRandom(seed, 2, "n") + "-1))'" +
+		":y='" + a + "+round(" + a + "*(2*" + seededRandom(seed, 3, "n") + "-1))'" +
+		",scale=" + strconv.Itoa(outWidth) + ":" + strconv.Itoa(outHeight) + ",setsar=1:1"
+}